@@ -0,0 +1,95 @@
+// Package strmem is a structured in-memory cache: a data array and the indexes over it, see package index
+package strmem
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/nikk-gr/strmem/errs"
+)
+
+// Changes is the difference between two data arrays
+type Changes struct {
+	// Added are the positions in the second data array of the records missing in the first one
+	Added []int
+	// Removed are the positions in the first data array of the records missing in the second one
+	Removed []int
+	// Changed are the records present in both data arrays with different values
+	Changed []Change
+}
+
+// Change is a record present in both data arrays with different values
+type Change struct {
+	// From is the position of the record in the first data array
+	From int
+	// To is the position of the record in the second data array
+	To int
+	// Fields are the names of the changed exported fields if the records are structs
+	Fields []string
+}
+
+// Diff compares the data arrays a and b, e.g. a rebuilt cache and the source of truth.
+// The records are matched by key and compared by reflect.DeepEqual.
+// Added and Changed are in the order of b, Removed is in the order of a.
+// The error wraps errs.ErrDuplicateKey if a key is repeated in one of the arrays
+func Diff[T any, K comparable](a, b []T, key func(item *T) K) (Changes, error) {
+	var res Changes
+	positions := make(map[K]int, len(a))
+	for j := range a {
+		k := key(&a[j])
+		if _, ok := positions[k]; ok {
+			return Changes{}, fmt.Errorf("first data array position %d: %w: %v", j, errs.ErrDuplicateKey, k)
+		}
+		positions[k] = j
+	}
+
+	matched := make([]bool, len(a))
+	seen := make(map[K]struct{}, len(b))
+	for j := range b {
+		k := key(&b[j])
+		if _, ok := seen[k]; ok {
+			return Changes{}, fmt.Errorf("second data array position %d: %w: %v", j, errs.ErrDuplicateKey, k)
+		}
+		seen[k] = struct{}{}
+		pos, ok := positions[k]
+		if !ok {
+			res.Added = append(res.Added, j)
+			continue
+		}
+		matched[pos] = true
+		if !reflect.DeepEqual(a[pos], b[j]) {
+			res.Changed = append(res.Changed, Change{
+				From:   pos,
+				To:     j,
+				Fields: changedFields(&a[pos], &b[j]),
+			})
+		}
+	}
+
+	for j, ok := range matched {
+		if !ok {
+			res.Removed = append(res.Removed, j)
+		}
+	}
+	return res, nil
+}
+
+// changedFields returns the names of the exported struct fields that differ between x and y,
+// or nil if T is not a struct
+func changedFields[T any](x, y *T) []string {
+	vx, vy := reflect.ValueOf(x).Elem(), reflect.ValueOf(y).Elem()
+	if vx.Kind() != reflect.Struct {
+		return nil
+	}
+	var fields []string
+	for j := 0; j < vx.NumField(); j++ {
+		if !vx.Type().Field(j).IsExported() {
+			// unexported fields can't be read through Interface
+			continue
+		}
+		if !reflect.DeepEqual(vx.Field(j).Interface(), vy.Field(j).Interface()) {
+			fields = append(fields, vx.Type().Field(j).Name)
+		}
+	}
+	return fields
+}
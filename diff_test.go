@@ -0,0 +1,69 @@
+package strmem
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/nikk-gr/strmem/errs"
+)
+
+func TestDiff(t *testing.T) {
+	type Entity struct {
+		ID    int
+		Name  string
+		Tags  []string
+		count int
+	}
+	id := func(e *Entity) int {
+		return e.ID
+	}
+
+	t.Run("changes", func(t *testing.T) {
+		a := []Entity{
+			{1, "a", nil, 0},
+			{2, "b", []string{"x"}, 0},
+			{3, "c", nil, 0},
+			{4, "d", nil, 0},
+		}
+		b := []Entity{
+			{4, "d", nil, 0},
+			{5, "e", nil, 0},
+			{2, "b", []string{"y"}, 0},
+			{1, "x", nil, 1},
+		}
+		res, err := Diff(a, b, id)
+		assert.NoError(t, err)
+		assert.Equal(t, Changes{
+			Added:   []int{1},
+			Removed: []int{2},
+			Changed: []Change{
+				{From: 1, To: 2, Fields: []string{"Tags"}},
+				{From: 0, To: 3, Fields: []string{"Name"}},
+			},
+		}, res)
+	})
+
+	t.Run("equal", func(t *testing.T) {
+		a := []Entity{{1, "a", []string{"x"}, 0}}
+		res, err := Diff(a, append([]Entity(nil), a...), id)
+		assert.NoError(t, err)
+		assert.Equal(t, Changes{}, res)
+	})
+
+	t.Run("not a struct", func(t *testing.T) {
+		res, err := Diff([]string{"a", "b"}, []string{"b", "c"}, func(s *string) string {
+			return *s
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, Changes{Added: []int{1}, Removed: []int{0}}, res)
+	})
+
+	t.Run("duplicate key", func(t *testing.T) {
+		_, err := Diff([]Entity{{ID: 1}, {ID: 1}}, nil, id)
+		assert.True(t, errors.Is(err, errs.ErrDuplicateKey))
+		_, err = Diff(nil, []Entity{{ID: 1}, {ID: 1}}, id)
+		assert.True(t, errors.Is(err, errs.ErrDuplicateKey))
+	})
+}
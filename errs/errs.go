@@ -0,0 +1,22 @@
+// Package errs contains the sentinel errors returned by strmem packages.
+// Errors returned by strmem wrap them, so they should be checked with errors.Is
+package errs
+
+import "errors"
+
+var (
+	// ErrNotFound is returned when there is no data for the requested key
+	ErrNotFound = errors.New("not found")
+	// ErrDuplicateKey is returned when a unique key is already in use
+	ErrDuplicateKey = errors.New("duplicate key")
+	// ErrConflict is returned when the data was changed by another writer
+	ErrConflict = errors.New("conflict")
+	// ErrInvalidSearchMethod is returned for an unknown index.SearchMethod
+	ErrInvalidSearchMethod = errors.New("invalid search method")
+	// ErrClosed is returned when the data is accessed after close
+	ErrClosed = errors.New("closed")
+	// ErrQuotaExceeded is returned when a mutation exceeds a size limit
+	ErrQuotaExceeded = errors.New("quota exceeded")
+	// ErrPanic is returned when a user-provided function, e.g. a field extractor, panics
+	ErrPanic = errors.New("callback panicked")
+)
@@ -0,0 +1,107 @@
+package index
+
+import (
+	"encoding/binary"
+	"hash/maphash"
+	"math"
+	"reflect"
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/google/btree"
+)
+
+// minBloomBits is the size of the bloom filter built for an empty index
+const minBloomBits = 1024
+
+// bloom is a bloom filter of the index keys.
+// Bits are only set, so the filter is safe to read without the index lock
+type bloom struct {
+	seed   maphash.Seed
+	bits   []atomic.Uint64
+	hashes uint64
+}
+
+// newBloom makes a bloom filter for n keys with the false positive rate p
+func newBloom(n int, p float64) *bloom {
+	m := uint64(math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2)))
+	if m < minBloomBits {
+		m = minBloomBits
+	}
+	k := uint64(math.Ceil(-math.Log2(p)))
+	return &bloom{
+		seed:   maphash.MakeSeed(),
+		bits:   make([]atomic.Uint64, (m+63)/64),
+		hashes: k,
+	}
+}
+
+// add puts the key hash into the filter
+func (b *bloom) add(h uint64) {
+	size := uint64(len(b.bits)) * 64
+	h1, h2 := h&math.MaxUint32, h>>32|1
+	for j := uint64(0); j < b.hashes; j++ {
+		bit := (h1 + j*h2) % size
+		word, mask := &b.bits[bit/64], uint64(1)<<(bit%64)
+		for {
+			old := word.Load()
+			if old&mask != 0 || word.CompareAndSwap(old, old|mask) {
+				break
+			}
+		}
+	}
+}
+
+// mayHave reports whether the key hash could be put into the filter
+func (b *bloom) mayHave(h uint64) bool {
+	size := uint64(len(b.bits)) * 64
+	h1, h2 := h&math.MaxUint32, h>>32|1
+	for j := uint64(0); j < b.hashes; j++ {
+		bit := (h1 + j*h2) % size
+		if b.bits[bit/64].Load()&(uint64(1)<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// bytes returns the memory used by the filter
+func (b *bloom) bytes() uintptr {
+	return uintptr(len(b.bits)) * unsafe.Sizeof(atomic.Uint64{})
+}
+
+// hashKey returns the hash of the index key
+func hashKey[T btree.Ordered](seed maphash.Seed, key T) uint64 {
+	var buf [8]byte
+	switch k := any(key).(type) {
+	case string:
+		return maphash.String(seed, k)
+	case int:
+		binary.LittleEndian.PutUint64(buf[:], uint64(k))
+	case int64:
+		binary.LittleEndian.PutUint64(buf[:], uint64(k))
+	case uint32:
+		binary.LittleEndian.PutUint64(buf[:], uint64(k))
+	case uint64:
+		binary.LittleEndian.PutUint64(buf[:], k)
+	default:
+		// named and less common types
+		v := reflect.ValueOf(key)
+		switch v.Kind() {
+		case reflect.String:
+			return maphash.String(seed, v.String())
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			binary.LittleEndian.PutUint64(buf[:], uint64(v.Int()))
+		case reflect.Float32, reflect.Float64:
+			f := v.Float()
+			if f == 0 {
+				// -0 is equal to 0
+				f = 0
+			}
+			binary.LittleEndian.PutUint64(buf[:], math.Float64bits(f))
+		default:
+			binary.LittleEndian.PutUint64(buf[:], v.Uint())
+		}
+	}
+	return maphash.Bytes(seed, buf[:])
+}
@@ -0,0 +1,72 @@
+package index
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBloomFilter(t *testing.T) {
+	type Entity struct {
+		Key int
+	}
+	data := make([]Entity, 1000)
+	for j := range data {
+		data[j].Key = j * 2
+	}
+	index := NewBTree(&data, func(e *Entity) int {
+		return e.Key
+	}, WithBloomFilter(0.01))
+
+	t.Run("no false negatives", func(t *testing.T) {
+		for j := range data {
+			assert.Equal(t, []int{j}, index.Get(j*2))
+		}
+		data = append(data, Entity{1})
+		index.Put(&data[len(data)-1], len(data)-1)
+		assert.Equal(t, []int{len(data) - 1}, index.Find(1, EQ))
+	})
+
+	t.Run("missing keys skip the lock", func(t *testing.T) {
+		before := index.LockStats().Get.Count
+		for j := 0; j < 1000; j++ {
+			assert.Nil(t, index.Get(j*2+10001))
+		}
+		assert.Less(t, index.LockStats().Get.Count-before, uint64(50))
+	})
+
+	t.Run("named and float keys", func(t *testing.T) {
+		type Score float64
+		scores := []Score{0, 1.5, -2}
+		index := NewBTree(&scores, func(s *Score) Score {
+			return *s
+		}, WithBloomFilter(0.01))
+		assert.Equal(t, []int{0}, index.Get(Score(negativeZero())))
+		assert.Equal(t, []int{1}, index.Get(1.5))
+		assert.Nil(t, index.Get(3))
+	})
+
+	t.Run("journaled keys before publication", func(t *testing.T) {
+		b := newBloom(10, 0.01)
+		addJournal(b, []journalEntry[int]{
+			{kind: OpPut, key: 5000, pos: 1},
+			{kind: OpReposition, key: 6000, pos: 2, newPos: 3},
+		})
+		assert.True(t, b.mayHave(hashKey(b.seed, 5000)))
+		assert.True(t, b.mayHave(hashKey(b.seed, 6000)))
+		addJournal[int](nil, nil)
+	})
+
+	t.Run("invalid rate", func(t *testing.T) {
+		assert.Panics(t, func() {
+			NewBTree(&data, func(e *Entity) int {
+				return e.Key
+			}, WithBloomFilter(1))
+		})
+	})
+}
+
+func negativeZero() float64 {
+	zero := 0.0
+	return -zero
+}
@@ -1,10 +1,15 @@
 package index
 
 import (
-	"errors"
-	"sync"
+	"context"
+	"fmt"
+	"sort"
+	"sync/atomic"
+	"unsafe"
 
 	"github.com/google/btree"
+
+	"github.com/nikk-gr/strmem/errs"
 )
 
 type SearchMethod uint8
@@ -25,191 +30,878 @@ const (
 // indexNode is a set of index of the base array with the same indexed data
 type indexNode[T btree.Ordered] struct {
 	index []int
-	data  T
+	// packed replaces index for the large sets, see WithPackedPostings
+	packed *packedPostings
+	data   T
+	// projections are the projections of the items at index, see Covering
+	projections []any
+}
+
+// positions returns the data array indexes of the node.
+// The slice is shared with the tree unless the postings are packed
+func (n indexNode[T]) positions() []int {
+	if n.packed != nil {
+		return n.packed.unpack()
+	}
+	return n.index
+}
+
+// appendTo appends the data array indexes of the node to arr
+func (n indexNode[T]) appendTo(arr []int) []int {
+	if n.packed != nil {
+		for _, b := range n.packed.blocks {
+			arr = b.appendTo(arr)
+		}
+		return arr
+	}
+	return append(arr, n.index...)
 }
 
+// first returns the first data array index of the node
+func (n indexNode[T]) first() int {
+	if n.packed != nil {
+		return n.packed.blocks[0].first
+	}
+	return n.index[0]
+}
+
+// count returns the number of data array indexes of the node
+func (n indexNode[T]) count() int {
+	if n.packed != nil {
+		return n.packed.count
+	}
+	return len(n.index)
+}
+
+// scanCheck is the number of keys FindContext and GetRangeContext visit between the checks of the context
+const scanCheck = 1024
+
+var (
+	_ Index[struct{}] = (*BTree[int, struct{}])(nil)
+	_ Ordered[int]    = (*BTree[int, struct{}])(nil)
+)
+
 // BTree is a balanced tree index for the cache data array
 type BTree[T btree.Ordered, A any] struct {
-	dataPtr  *[]A
-	rw       sync.RWMutex
-	tree     *btree.BTreeG[indexNode[T]]
-	getField func(cache *A) T
+	locker
+	dataPtr   *[]A
+	tree      *btree.BTreeG[indexNode[T]]
+	getField  func(cache *A) T
+	degree    int
+	sorted    bool
+	predicate func(item *A) bool
+	writer    *writer[T]
+	// packThreshold is the posting list length to be packed, 0 means the postings are not sorted
+	packThreshold int
+	bloomRate     float64
+	bloom         atomic.Pointer[bloom]
+	lazy          lazyBuild
+	rebuilding    rebuildState[T]
+	pace          int
+	progress      func(p Progress)
+	// order compares the items of one posting list, see WithPostingOrder
+	order func(x, y *A) bool
+	// project returns the projection stored next to the posting of the item, see Covering
+	project func(item *A) any
 }
 
 // NewBTree make a balanced tree index for the cache data array
 // data is an array of any type data
 // field is a function that returns the field that should be indexed
+// opts are the index options, see WithDegree, WithLocking, WithSortedResults and WithPredicate
 func NewBTree[T btree.Ordered, A any](
 	data *[]A,
 	field func(cache *A) T,
+	opts ...Option,
+) *BTree[T, A] {
+	return newBTree(data, field, nil, opts)
+}
+
+// newBTree is NewBTree that stores the projections of the items made by project if it is not nil
+func newBTree[T btree.Ordered, A any](
+	data *[]A,
+	field func(cache *A) T,
+	project func(item *A) any,
+	opts []Option,
 ) *BTree[T, A] {
+	o := newOptions(opts)
 	ind := BTree[T, A]{
-		dataPtr:  data,
-		getField: field,
+		dataPtr:       data,
+		getField:      field,
+		degree:        o.degree,
+		sorted:        o.sorted,
+		predicate:     predicateFor[A](o),
+		bloomRate:     o.bloomRate,
+		packThreshold: o.packThreshold,
+		pace:          o.pace,
+		progress:      o.progress,
+		order:         postingOrderFor[A](o),
+		project:       project,
+	}
+	if ind.project != nil && ind.packThreshold > 0 {
+		panic(fmt.Errorf("index: Covering can't be combined with WithPackedPostings"))
+	}
+	if ind.order != nil && (ind.sorted || ind.packThreshold > 0) {
+		panic(fmt.Errorf("index: WithPostingOrder can't be combined with WithSortedResults or WithPackedPostings"))
+	}
+	if ind.order != nil && o.lockMode == SingleWriter {
+		// the queued mutations would compare the items of the data array changed after the call
+		panic(fmt.Errorf("index: WithPostingOrder can't be combined with the SingleWriter mode"))
+	}
+	ind.locker.disabled = o.lockMode != RWLock
+	if o.lockMode == SingleWriter {
+		tree, b, err := ind.buildTree(context.Background())
+		if err != nil {
+			panic(err)
+		}
+		ind.tree = tree
+		ind.bloom.Store(b)
+		ind.lazy.built.Store(true)
+		ind.writer = newWriter(ind.tree.Clone())
+		go ind.write()
+		return &ind
+	}
+	if o.lazy {
+		ind.tree = ind.newTree()
+		return &ind
 	}
 	ind.Rebuild()
 	return &ind
 }
 
-// Rebuild removes the old index and builds new
-func (i *BTree[T, A]) Rebuild() {
-	i.rw.Lock()
-	defer i.rw.Unlock()
-	i.tree = btree.NewG(4, func(a, b indexNode[T]) bool {
-		return a.data < b.data
+// build builds the index on the first query if it is created WithLazyBuild or disabled
+func (i *BTree[T, A]) build() {
+	i.lazy.ensure(func() {
+		if i.writer != nil {
+			i.writer.enqueue(i.rebuild)
+			i.Sync()
+			return
+		}
+		if err := i.rebuildContext(context.Background()); err != nil {
+			panic(err)
+		}
 	})
+}
 
-	var (
-		tmpINode indexNode[T]
-		ok       bool
-		tmpData  T
-	)
-	for j := range *i.dataPtr {
-		tmpData = i.getField(&(*i.dataPtr)[j])
-		tmpINode, ok = i.tree.Get(indexNode[T]{
-			data: tmpData,
-		})
-		if ok {
-			tmpINode.index = append(tmpINode.index, j)
-		} else {
-			tmpINode = indexNode[T]{
-				index: []int{j},
-				data:  tmpData,
-			}
-		}
-		tmpINode, ok =
-			i.tree.ReplaceOrInsert(tmpINode)
-	}
+// Disable drops the index, e.g. for a bulk load of the data array.
+// Mutations are skipped until the index is built again by Enable, Rebuild or the next query
+func (i *BTree[T, A]) Disable() {
+	i.lazy.mu.Lock()
+	defer i.lazy.mu.Unlock()
+	i.mutate(opRebuild, func() {
+		i.tree = i.newTree()
+	})
+	i.Sync()
+	i.lazy.built.Store(false)
+}
+
+// Enable builds the disabled index from the data array
+func (i *BTree[T, A]) Enable() {
+	i.build()
+}
+
+// newTree returns an empty tree of the index
+func (i *BTree[T, A]) newTree() *btree.BTreeG[indexNode[T]] {
+	return btree.NewG(i.degree, func(a, b indexNode[T]) bool {
+		return a.data < b.data
+	})
 }
 
 // Get returns the slice of data array indexes that match selected key
 func (i *BTree[T, A]) Get(key T) []int {
-	i.rw.RLock()
-	defer i.rw.RUnlock()
-	iNode, ok := i.tree.Get(indexNode[T]{
+	i.build()
+	if !i.mayHave(key) {
+		return nil
+	}
+	tree, unlock := i.reader(opGet)
+	defer unlock()
+	return i.result(get(tree, key), true)
+}
+
+// get is Get from the tree returned by reader
+func get[T btree.Ordered](tree *btree.BTreeG[indexNode[T]], key T) []int {
+	iNode, ok := tree.Get(indexNode[T]{
 		data: key,
 	})
 	if !ok {
 		return nil
 	}
-	return iNode.index
+	return iNode.positions()
 }
 
-// Put returns the slice of data array indexes that match selected key
+// Put adds the item stored at the index position of the data array
 func (i *BTree[T, A]) Put(item *A, index int) {
-	i.rw.Lock()
-	defer i.rw.Unlock()
-	var (
-		tmpINode indexNode[T]
-		ok       bool
-		tmpData  T
-	)
-	tmpData = i.getField(item)
-	tmpINode, ok = i.tree.Get(indexNode[T]{
-		data: tmpData,
+	pending, waited := i.lazy.pending()
+	if pending || !i.accepts(item) {
+		return
+	}
+	key, projection := i.getField(item), i.projectionOf(item)
+	if i.writer != nil {
+		i.writer.enqueue(func() {
+			i.put(key, index, item, projection)
+		})
+		return
+	}
+	unlock, rebuilt := i.writeLock(opPut)
+	defer unlock()
+	if (waited || rebuilt) && i.contains(i.tree, key, index) {
+		return
+	}
+	i.put(key, index, item, projection)
+}
+
+// projectionOf returns the projection of the item stored in the index, nil if there are no projections
+func (i *BTree[T, A]) projectionOf(item *A) any {
+	if i.project == nil {
+		return nil
+	}
+	return i.project(item)
+}
+
+// put is Put for callers that already hold the lock.
+// item is the item at the index, nil means it is read from the data array.
+// projection is the projection of the item, see projectionOf
+func (i *BTree[T, A]) put(key T, index int, item *A, projection any) {
+	i.record(OpPut, key, index, 0, projection)
+	if b := i.bloom.Load(); b != nil {
+		b.add(hashKey(b.seed, key))
+	}
+	tmpINode, ok := i.tree.Get(indexNode[T]{
+		data: key,
 	})
 	if ok {
-		tmpINode.index = append(tmpINode.index, index)
+		tmpINode = i.add(tmpINode, index, item, projection)
 	} else {
 		tmpINode = indexNode[T]{
 			index: []int{index},
-			data:  tmpData,
+			data:  key,
+		}
+		if i.project != nil {
+			tmpINode.projections = []any{projection}
+		}
+	}
+	i.tree.ReplaceOrInsert(tmpINode)
+}
+
+// add returns the node with the data array index of the item and its projection added, see put.
+// The posting slice could be returned by Get, so it is never changed in place
+func (i *BTree[T, A]) add(n indexNode[T], index int, item *A, projection any) indexNode[T] {
+	switch {
+	case n.packed != nil:
+		n.packed = n.packed.insert(index)
+	case i.order != nil:
+		j := i.orderedSearch(n.index, index, item)
+		n.index = insertAt(n.index, j, index)
+		if i.project != nil {
+			n.projections = insertAt(n.projections, j, projection)
+		}
+	case i.packThreshold == 0:
+		// append doesn't change the part of the slice seen by the readers
+		n.index = append(n.index, index)
+		if i.project != nil {
+			n.projections = append(n.projections, projection)
+		}
+	default:
+		n.index = sortedInsert(n.index, index)
+		if len(n.index) > i.packThreshold {
+			n.packed, n.index = packPostings(n.index), nil
+		}
+	}
+	return n
+}
+
+// remove returns the node without the data array index and reports whether the node had it.
+// The posting slice could be returned by Get, so it is never changed in place
+func (i *BTree[T, A]) remove(n indexNode[T], index int) (indexNode[T], bool) {
+	switch {
+	case n.packed != nil:
+		packed, ok := n.packed.remove(index)
+		if !ok {
+			return n, false
+		}
+		n.packed = packed
+		if packed.count <= i.packThreshold/2 {
+			n.packed, n.index = nil, packed.unpack()
+		}
+	case i.order != nil || i.project != nil:
+		// the postings keep their order, the projections stay next to them
+		j := positionOf(n.index, index)
+		if j < 0 {
+			return n, false
+		}
+		n.index = removeAt(n.index, j)
+		if i.project != nil {
+			n.projections = removeAt(n.projections, j)
+		}
+	case i.packThreshold == 0:
+		postings := rmFromArr(append([]int(nil), n.index...), index)
+		if len(postings) == len(n.index) {
+			return n, false
+		}
+		n.index = postings
+	default:
+		postings, ok := sortedRemove(n.index, index)
+		if !ok {
+			return n, false
+		}
+		n.index = postings
+	}
+	return n, true
+}
+
+// orderedSearch returns the place of the data array index of the item in the postings:
+// after the positions whose items are not greater than the item by the posting order.
+// A nil item is read from the data array
+func (i *BTree[T, A]) orderedSearch(postings []int, index int, item *A) int {
+	data := *i.dataPtr
+	if item == nil {
+		item = &data[index]
+	}
+	return sort.Search(len(postings), func(j int) bool {
+		return i.order(item, &data[postings[j]])
+	})
+}
+
+// insertAt returns a copy of s with v inserted at j
+func insertAt[E any](s []E, j int, v E) []E {
+	res := make([]E, len(s)+1)
+	copy(res, s[:j])
+	res[j] = v
+	copy(res[j+1:], s[j:])
+	return res
+}
+
+// removeAt returns a copy of s without its element j
+func removeAt[E any](s []E, j int) []E {
+	res := make([]E, 0, len(s)-1)
+	res = append(res, s[:j]...)
+	return append(res, s[j+1:]...)
+}
+
+// positionOf returns the place of val in the postings or -1
+func positionOf(postings []int, val int) int {
+	for j := range postings {
+		if postings[j] == val {
+			return j
 		}
 	}
-	tmpINode, ok =
-		i.tree.ReplaceOrInsert(tmpINode)
+	return -1
 }
 
+// Rm removes the item stored at the index position of the data array.
+// Nothing is removed if the key of the item doesn't have this position
 func (i *BTree[T, A]) Rm(item *A, index int) {
-	tmpData := i.getField(item)
-	key := i.Get(tmpData)
-	if key == nil {
-		i.Rebuild()
-	}
-
-	if len(key) > 1 {
-		key = rmFromArr(key, index)
-		i.rw.Lock()
-		defer i.rw.Unlock()
-		i.tree.ReplaceOrInsert(indexNode[T]{
-			index: key,
-			data:  tmpData,
-		})
+	if !i.accepts(item) {
 		return
 	}
-	i.rw.Lock()
-	defer i.rw.Unlock()
-	i.tree.Delete(indexNode[T]{
-		index: []int{index},
-		data:  tmpData,
+	i.RmByKeyIndex(i.getField(item), index)
+}
+
+// RmByKeyIndex removes the data array index from the key.
+// It reports whether the key had this index.
+// In the SingleWriter mode the removal is queued and true is returned
+func (i *BTree[T, A]) RmByKeyIndex(key T, index int) bool {
+	// rm is idempotent, so a removal that waited for the lazy build is applied as is
+	if pending, _ := i.lazy.pending(); pending {
+		return true
+	}
+	if i.writer != nil {
+		i.writer.enqueue(func() {
+			i.rm(key, index)
+		})
+		return true
+	}
+	unlock, _ := i.writeLock(opRm)
+	defer unlock()
+	return i.rm(key, index)
+}
+
+// rm is RmByKeyIndex for callers that already hold the lock
+func (i *BTree[T, A]) rm(key T, index int) bool {
+	i.record(OpRm, key, index, 0, nil)
+	iNode, ok := i.tree.Get(indexNode[T]{
+		data: key,
 	})
+	if !ok {
+		return false
+	}
+	iNode, ok = i.remove(iNode, index)
+	if !ok {
+		return false
+	}
+	if iNode.count() == 0 {
+		i.tree.Delete(iNode)
+		return true
+	}
+	i.tree.ReplaceOrInsert(iNode)
+	return true
 }
 
+// Contains reports whether the key has the data array index.
+// It uses binary search if the postings are sorted, see WithPackedPostings
+func (i *BTree[T, A]) Contains(key T, index int) bool {
+	i.build()
+	if !i.mayHave(key) {
+		return false
+	}
+	tree, unlock := i.reader(opGet)
+	defer unlock()
+	return i.contains(tree, key, index)
+}
+
+// contains is Contains in the tree returned by reader
+func (i *BTree[T, A]) contains(tree *btree.BTreeG[indexNode[T]], key T, index int) bool {
+	iNode, ok := tree.Get(indexNode[T]{
+		data: key,
+	})
+	switch {
+	case !ok:
+		return false
+	case iNode.packed != nil:
+		return iNode.packed.contains(index)
+	case i.packThreshold > 0:
+		j := sort.SearchInts(iNode.index, index)
+		return j < len(iNode.index) && iNode.index[j] == index
+	}
+	for _, pos := range iNode.index {
+		if pos == index {
+			return true
+		}
+	}
+	return false
+}
+
+// Reposition moves the item from oldPos to newPos of the data array, e.g. after a swap-delete.
+// It reports whether the key of the item had oldPos.
+// In the SingleWriter mode the change is queued and true is returned
+func (i *BTree[T, A]) Reposition(item *A, oldPos, newPos int) bool {
+	if !i.accepts(item) {
+		return false
+	}
+	pending, waited := i.lazy.pending()
+	if pending {
+		return true
+	}
+	key := i.getField(item)
+	if i.writer != nil {
+		i.writer.enqueue(func() {
+			i.reposition(key, oldPos, newPos)
+		})
+		return true
+	}
+	unlock, rebuilt := i.writeLock(opReposition)
+	defer unlock()
+	if waited || rebuilt {
+		i.replay([]journalEntry[T]{{
+			kind:       OpReposition,
+			key:        key,
+			pos:        oldPos,
+			newPos:     newPos,
+			projection: i.projectionOf(item),
+		}})
+		return true
+	}
+	return i.reposition(key, oldPos, newPos)
+}
+
+// reposition is Reposition for callers that already hold the lock
+func (i *BTree[T, A]) reposition(key T, oldPos, newPos int) bool {
+	projection, ok := i.move(key, oldPos, newPos)
+	if !ok {
+		return false
+	}
+	i.record(OpReposition, key, oldPos, newPos, projection)
+	return true
+}
+
+// move replaces oldPos of the key by newPos and reports whether the key had oldPos.
+// The projection stays next to the posting and is returned
+func (i *BTree[T, A]) move(key T, oldPos, newPos int) (projection any, ok bool) {
+	iNode, ok := i.tree.Get(indexNode[T]{
+		data: key,
+	})
+	if !ok {
+		return nil, false
+	}
+	if iNode.packed != nil || i.packThreshold > 0 {
+		// sorted postings keep the order, they have no projections
+		iNode, ok = i.remove(iNode, oldPos)
+		if !ok {
+			return nil, false
+		}
+		i.tree.ReplaceOrInsert(i.add(iNode, newPos, nil, nil))
+		return nil, true
+	}
+	for j := range iNode.index {
+		if iNode.index[j] == oldPos {
+			// the posting slice could be returned by Get, so it is copied before the change
+			iNode.index = append([]int(nil), iNode.index...)
+			iNode.index[j] = newPos
+			i.tree.ReplaceOrInsert(iNode)
+			if i.project != nil {
+				projection = iNode.projections[j]
+			}
+			return projection, true
+		}
+	}
+	return nil, false
+}
+
+// Apply executes the batch of operations under one lock.
+// If one of the operations fails, none of them is applied and the error wraps errs.ErrNotFound,
+// or errs.ErrPanic if the field extractor panics.
+// In the SingleWriter mode Apply waits until the batch is published
+func (i *BTree[T, A]) Apply(ops []Op[A]) (err error) {
+	pending, waited := i.lazy.pending()
+	if pending {
+		return nil
+	}
+	if waited {
+		return i.applyIdempotent(ops)
+	}
+	if i.writer != nil {
+		i.writer.await(func() {
+			err = i.apply(ops)
+		})
+		return err
+	}
+	unlock, rebuilt := i.writeLock(opApply)
+	defer unlock()
+	if rebuilt {
+		journal, err := i.journalOf(ops)
+		if err != nil {
+			return err
+		}
+		i.replay(journal)
+		return nil
+	}
+	return i.apply(ops)
+}
+
+// apply is Apply for callers that already hold the lock.
+// A panic of the user-provided functions is returned as *PanicError
+func (i *BTree[T, A]) apply(ops []Op[A]) (err error) {
+	// the clone is copy-on-write, so it is cheap to throw away if the batch fails
+	tree, journaled := i.tree, len(i.rebuilding.journal)
+	i.tree = tree.Clone()
+	defer func() {
+		if err != nil {
+			i.tree = tree
+			i.rebuilding.journal = i.rebuilding.journal[:journaled]
+		}
+	}()
+	defer recoverTo(&err)
+	for j, op := range ops {
+		if err := i.applyOp(op); err != nil {
+			return fmt.Errorf("op %d: %w", j, err)
+		}
+	}
+	return nil
+}
+
+// applyIdempotent applies the batch that waited for the lazy build.
+// The build could already read some of the operations from the data array,
+// so they are applied idempotently and can't fail
+func (i *BTree[T, A]) applyIdempotent(ops []Op[A]) error {
+	journal, err := i.journalOf(ops)
+	if err != nil {
+		return err
+	}
+	i.mutate(opApply, func() {
+		i.replay(journal)
+	})
+	return nil
+}
+
+// journalOf returns the journal entries of the batch to be replayed.
+// A panic of the user-provided functions is returned as *PanicError
+func (i *BTree[T, A]) journalOf(ops []Op[A]) (_ []journalEntry[T], err error) {
+	defer recoverTo(&err)
+	journal := make([]journalEntry[T], 0, len(ops))
+	for _, op := range ops {
+		if i.accepts(op.Item) {
+			journal = append(journal, journalEntry[T]{
+				kind:   op.Kind,
+				key:    i.getField(op.Item),
+				pos:    op.Pos,
+				newPos: op.NewPos,
+			})
+			if op.Kind != OpRm {
+				journal[len(journal)-1].projection = i.projectionOf(op.Item)
+			}
+		}
+	}
+	return journal, nil
+}
+
+// applyOp executes one batch operation, the lock must be held
+func (i *BTree[T, A]) applyOp(op Op[A]) error {
+	if !i.accepts(op.Item) {
+		return nil
+	}
+	key := i.getField(op.Item)
+	switch op.Kind {
+	case OpPut:
+		i.put(key, op.Pos, op.Item, i.projectionOf(op.Item))
+	case OpRm:
+		if !i.rm(key, op.Pos) {
+			return fmt.Errorf("rm position %d: %w", op.Pos, errs.ErrNotFound)
+		}
+	case OpReposition:
+		if !i.reposition(key, op.Pos, op.NewPos) {
+			return fmt.Errorf("reposition %d: %w", op.Pos, errs.ErrNotFound)
+		}
+	default:
+		return fmt.Errorf("invalid op kind %d", op.Kind)
+	}
+	return nil
+}
+
+// Find returns the slice of data array indexes whose keys match key by method.
+// It panics with an error wrapping errs.ErrInvalidSearchMethod if the method is unknown
 func (i *BTree[T, A]) Find(key T, method SearchMethod) []int {
-	i.rw.RLock()
-	defer i.rw.RUnlock()
+	data, _ := i.FindContext(context.Background(), key, method)
+	return data
+}
+
+// FindContext is Find that stops the scan of the keys and returns ctx.Err() when ctx is done
+func (i *BTree[T, A]) FindContext(ctx context.Context, key T, method SearchMethod) ([]int, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	i.build()
+	if method == EQ && !i.mayHave(key) {
+		return nil, nil
+	}
+	tree, unlock := i.reader(opFind)
+	defer unlock()
 	if method == EQ {
-		return i.Get(key)
+		return i.result(get(tree, key), true), nil
+	}
+
+	var (
+		data    []int
+		visited int
+		err     error
+	)
+	visit(tree, key, method, func(in indexNode[T]) bool {
+		if visited++; visited%scanCheck == 0 {
+			if err = ctx.Err(); err != nil {
+				return false
+			}
+		}
+		data = in.appendTo(data)
+		return true
+	})
+	if err != nil {
+		return nil, err
 	}
+	return i.result(data, false), nil
+}
 
+// visit calls fn for the nodes of the tree whose keys match key by method until fn returns false.
+// It panics with an error wrapping errs.ErrInvalidSearchMethod if the method is unknown
+func visit[T btree.Ordered](tree *btree.BTreeG[indexNode[T]], key T, method SearchMethod, fn func(in indexNode[T]) bool) {
 	iNode := indexNode[T]{
 		data: key,
 	}
-	var data []int
-	saver := func(in indexNode[T]) bool {
-		data = append(data, in.index...)
-		return true
-	}
 	switch method {
+	case EQ:
+		if in, ok := tree.Get(iNode); ok {
+			fn(in)
+		}
 	case GT:
-		i.tree.DescendGreaterThan(iNode, saver)
+		tree.DescendGreaterThan(iNode, fn)
 	case GTE:
-		i.tree.AscendGreaterOrEqual(iNode, saver)
+		tree.AscendGreaterOrEqual(iNode, fn)
 	case LT:
-		i.tree.AscendLessThan(iNode, saver)
+		tree.AscendLessThan(iNode, fn)
 	case LTE:
-		i.tree.DescendLessOrEqual(iNode, saver)
+		tree.DescendLessOrEqual(iNode, fn)
 	default:
-		panic(errors.New("invalid search method"))
+		panic(fmt.Errorf("%w: %d", errs.ErrInvalidSearchMethod, method))
 	}
-	return data
 }
 
+// GetRange returns the slice of data array indexes whose keys are between from and to
 func (i *BTree[T, A]) GetRange(from, to T, includeFrom, includeTo bool) []int {
-	i.rw.RLock()
-	defer i.rw.RUnlock()
+	data, _ := i.GetRangeContext(context.Background(), from, to, includeFrom, includeTo)
+	return data
+}
+
+// GetRangeContext is GetRange that stops the scan of the keys and returns ctx.Err() when ctx is done
+func (i *BTree[T, A]) GetRangeContext(ctx context.Context, from, to T, includeFrom, includeTo bool) ([]int, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	i.build()
+	tree, unlock := i.reader(opGetRange)
+	defer unlock()
 	if to == from {
 		if includeFrom && includeTo {
-			return i.Get(from)
+			return i.result(get(tree, from), true), nil
 		}
-		return nil
+		return nil, nil
 	}
 
 	if from > to {
 		to, from = from, to
 	}
 
-	var data []int
+	var (
+		data    []int
+		visited int
+		err     error
+	)
 	saver := func(in indexNode[T]) bool {
 		if !includeFrom && in.data == from {
 			return true
 		}
-		if !includeTo && in.data == to {
+		if in.data > to || !includeTo && in.data == to {
 			return false
 		}
-		if in.data > to {
-			return false
+		if visited++; visited%scanCheck == 0 {
+			if err = ctx.Err(); err != nil {
+				return false
+			}
 		}
-		data = append(data, in.index...)
+		data = in.appendTo(data)
 		return true
 	}
 
-	i.tree.DescendGreaterThan(indexNode[T]{
+	tree.AscendGreaterOrEqual(indexNode[T]{
 		data: from,
 	}, saver)
+	if err != nil {
+		return nil, err
+	}
+	return i.result(data, false), nil
+}
+
+// PeekMin returns the smallest key and its first data array index.
+// The index is the first by WithPostingOrder, the smallest with WithPackedPostings
+// and the earliest put otherwise. ok is false if the index is empty
+func (i *BTree[T, A]) PeekMin() (key T, index int, ok bool) {
+	i.build()
+	tree, unlock := i.reader(opGet)
+	defer unlock()
+	n, ok := tree.Min()
+	if !ok {
+		return key, 0, false
+	}
+	return n.data, n.first(), true
+}
+
+// PopMin removes the data array index returned by PeekMin from the index and returns it,
+// so the index can be used as a priority queue, e.g. by a deadline field.
+// The caller removes the item from the data array and repositions the moved one.
+// In the SingleWriter mode PopMin waits until the removal is published
+func (i *BTree[T, A]) PopMin() (key T, index int, ok bool) {
+	i.build()
+	pop := func() {
+		var n indexNode[T]
+		if n, ok = i.tree.Min(); ok {
+			key, index = n.data, n.first()
+			i.rm(key, index)
+		}
+	}
+	if i.writer != nil {
+		i.writer.await(pop)
+		return key, index, ok
+	}
+	unlock, _ := i.writeLock(opRm)
+	defer unlock()
+	pop()
+	return key, index, ok
+}
+
+// reader returns the tree for reading and the function that releases it
+func (i *BTree[T, A]) reader(op lockOp) (*btree.BTreeG[indexNode[T]], func()) {
+	if i.writer != nil {
+		return i.writer.published.Load(), noop
+	}
+	unlock := i.rlock(op)
+	return i.tree, unlock
+}
+
+// mutate runs fn under the write lock or queues it in the SingleWriter mode
+func (i *BTree[T, A]) mutate(op lockOp, fn func()) {
+	if i.writer != nil {
+		i.writer.enqueue(fn)
+		return
+	}
+	unlock, _ := i.writeLock(op)
+	defer unlock()
+	fn()
+}
+
+// writeLock takes the write lock for a mutation and returns the function that releases it.
+// The mutations of an index WithPostingOrder also wait for a running Rebuild,
+// because the replay of their journal would compare the items of the data array changed since.
+// rebuilt reports whether the mutation waited for Rebuild, which could already read the change
+// from the data array, so such a mutation must be applied idempotently
+func (i *BTree[T, A]) writeLock(op lockOp) (unlock func(), rebuilt bool) {
+	if i.order == nil || i.locker.disabled {
+		return i.lock(op), false
+	}
+	swaps := i.rebuilding.swaps.Load()
+	if !i.rebuilding.gate.TryLock() {
+		testHookWait()
+		i.rebuilding.gate.Lock()
+	}
+	rebuilt = i.rebuilding.swaps.Load() != swaps
+	unlockTree := i.lock(op)
+	return func() {
+		unlockTree()
+		i.rebuilding.gate.Unlock()
+	}, rebuilt
+}
+
+// mayHave reports whether the key could be in the index, it doesn't take the lock
+func (i *BTree[T, A]) mayHave(key T) bool {
+	b := i.bloom.Load()
+	return b == nil || b.mayHave(hashKey(b.seed, key))
+}
+
+// accepts reports whether the item passes the index predicate
+func (i *BTree[T, A]) accepts(item *A) bool {
+	return i.predicate == nil || i.predicate(item)
+}
+
+// result prepares the data array indexes found in the tree to be returned to the caller.
+// shared reports whether data is a slice stored in the tree
+func (i *BTree[T, A]) result(data []int, shared bool) []int {
+	if !i.sorted || data == nil {
+		return data
+	}
+	if shared {
+		data = append([]int(nil), data...)
+	}
+	sort.Ints(data)
 	return data
 }
 
+// Stats returns the number of keys and postings in the index and its memory estimate
+func (i *BTree[T, A]) Stats() (s Stats) {
+	tree, unlock := i.reader(opStats)
+	defer unlock()
+	var postingsBytes uintptr
+	tree.Ascend(func(in indexNode[T]) bool {
+		s.Postings += in.count()
+		postingsBytes += uintptr(cap(in.index))*postingSize + uintptr(cap(in.projections))*unsafe.Sizeof(any(nil))
+		if in.packed != nil {
+			postingsBytes += in.packed.bytes()
+		}
+		return true
+	})
+	s.Keys = tree.Len()
+	s.Bytes = uintptr(s.Keys)*unsafe.Sizeof(indexNode[T]{}) + postingsBytes
+	if b := i.bloom.Load(); b != nil {
+		s.Bytes += b.bytes()
+	}
+	return s
+}
+
 func rmFromArr[T btree.Ordered](arr []T, val T) []T {
 	var shortener int
 	for i := range arr {
@@ -1,10 +1,14 @@
 package index
 
 import (
+	"context"
+	"errors"
 	"sort"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+
+	"github.com/nikk-gr/strmem/errs"
 )
 
 func TestBtree(t *testing.T) {
@@ -83,6 +87,14 @@ func TestBtree(t *testing.T) {
 		sort.Ints(actual)
 		assert.Equal(t, expectation, actual)
 	})
+	t.Run("Invalid search method", func(t *testing.T) {
+		cache := init()
+		defer func() {
+			err, _ := recover().(error)
+			assert.True(t, errors.Is(err, errs.ErrInvalidSearchMethod))
+		}()
+		cache.index.Find(6, SearchMethod(100))
+	})
 	t.Run("Add uniq val and get", func(t *testing.T) {
 		cache := init()
 		*cache.data = append(*cache.data, Entity{10, 20})
@@ -126,6 +138,89 @@ func TestBtree(t *testing.T) {
 		expectation2 := []int{5, 8}
 		assert.Equal(t, expectation2, actual2, "index of the replaced value are wrong")
 	})
+	t.Run("Remove with wrong index", func(t *testing.T) {
+		cache := init()
+		// key 7 has only index 5
+		cache.index.Rm(&(*cache.data)[5], 6)
+		assert.Equal(t, []int{5}, cache.index.Get(7))
+		assert.Equal(t, uint64(1), cache.index.LockStats().Rebuild.Count, "unexpected rebuild")
+	})
+	t.Run("Remove by key and index", func(t *testing.T) {
+		cache := init()
+		got := cache.index.Get(1)
+		assert.True(t, cache.index.RmByKeyIndex(1, 2))
+		assert.Equal(t, []int{1}, cache.index.Get(1))
+		assert.Equal(t, []int{1, 2}, got, "returned slice was changed")
+		assert.False(t, cache.index.RmByKeyIndex(1, 2))
+		assert.False(t, cache.index.RmByKeyIndex(100, 2))
+		assert.True(t, cache.index.RmByKeyIndex(1, 1))
+		assert.Nil(t, cache.index.Get(1))
+	})
+	t.Run("Remove val with reposition", func(t *testing.T) {
+		cache := init()
+		indexToBeRemoved := 5
+		last := len(*cache.data) - 1
+		cache.index.Rm(&(*cache.data)[indexToBeRemoved], indexToBeRemoved)
+		// the last element keeps its key, so only its position is changed
+		assert.True(t, cache.index.Reposition(&(*cache.data)[last], last, indexToBeRemoved))
+		(*cache.data)[indexToBeRemoved] = (*cache.data)[last]
+		*cache.data = (*cache.data)[:last]
+
+		assert.Nil(t, cache.index.Get(7))
+		actual := cache.index.Get(10)
+		sort.Ints(actual)
+		assert.Equal(t, []int{5, 8}, actual)
+		assert.False(t, cache.index.Reposition(&(*cache.data)[0], last, 1))
+	})
+	t.Run("Apply batch", func(t *testing.T) {
+		cache := init()
+		last := len(*cache.data) - 1
+		*cache.data = append(*cache.data, Entity{10, 1})
+		err := cache.index.Apply([]Op[Entity]{
+			{Kind: OpRm, Item: &(*cache.data)[5], Pos: 5},
+			{Kind: OpReposition, Item: &(*cache.data)[last], Pos: last, NewPos: 5},
+			{Kind: OpPut, Item: &(*cache.data)[last+1], Pos: last + 1},
+		})
+		assert.NoError(t, err)
+		assert.Nil(t, cache.index.Get(7))
+		actual := cache.index.Get(10)
+		sort.Ints(actual)
+		assert.Equal(t, []int{5, 8}, actual)
+		actual = cache.index.Get(1)
+		sort.Ints(actual)
+		assert.Equal(t, []int{1, 2, 10}, actual)
+	})
+	t.Run("Apply failed batch", func(t *testing.T) {
+		cache := init()
+		err := cache.index.Apply([]Op[Entity]{
+			{Kind: OpRm, Item: &(*cache.data)[5], Pos: 5},
+			{Kind: OpPut, Item: &(*cache.data)[5], Pos: 20},
+			{Kind: OpRm, Item: &(*cache.data)[0], Pos: 1},
+		})
+		assert.True(t, errors.Is(err, errs.ErrNotFound))
+		assert.Equal(t, []int{5}, cache.index.Get(7), "batch was partially applied")
+		actual := cache.index.Get(6)
+		sort.Ints(actual)
+		assert.Equal(t, []int{0, 4}, actual)
+	})
+}
+
+func TestBtreeGetRange(t *testing.T) {
+	type Entity struct {
+		Key int
+	}
+	data := []Entity{{6}, {1}, {1}, {5}, {6}, {7}, {8}, {8}, {10}, {10}}
+	index := NewBTree(&data, func(e *Entity) int {
+		return e.Key
+	}, WithSortedResults())
+
+	// ranges that don't reach the maximum key
+	assert.Equal(t, []int{0, 3, 4, 5, 6, 7}, index.GetRange(5, 8, true, true))
+	assert.Equal(t, []int{0, 4, 5}, index.GetRange(5, 8, false, false))
+	assert.Equal(t, []int{1, 2, 3}, index.GetRange(0, 5, true, true))
+	assert.Equal(t, []int{0, 3, 4}, index.GetRange(6, 2, true, true))
+	assert.Equal(t, []int{8, 9}, index.GetRange(9, 10, true, true))
+	assert.Nil(t, index.GetRange(2, 4, true, true))
 }
 
 func TestRmFromArr(t *testing.T) {
@@ -185,3 +280,111 @@ func TestRmFromArr(t *testing.T) {
 		})
 	}
 }
+
+func TestBtreeStats(t *testing.T) {
+	type Entity struct {
+		Key uint32
+	}
+	data := []Entity{{6}, {1}, {1}, {5}, {6}}
+	index := NewBTree(&data, func(e *Entity) uint32 {
+		return e.Key
+	})
+	stats := index.Stats()
+	assert.Equal(t, 3, stats.Keys)
+	assert.Equal(t, 5, stats.Postings)
+	assert.NotZero(t, stats.Bytes)
+}
+
+func TestBtreePopMin(t *testing.T) {
+	type Job struct {
+		Deadline int
+		Priority int
+	}
+	byPriority := WithPostingOrder(func(x, y *Job) bool {
+		return x.Priority > y.Priority
+	})
+	for _, c := range []struct {
+		mode   LockMode
+		opts   []Option
+		popped []int
+	}{
+		{RWLock, []Option{byPriority}, []int{3, 1, 2, 0}},
+		{SingleWriter, nil, []int{1, 3, 2, 0}},
+	} {
+		mode := c.mode
+		data := []Job{{30, 1}, {10, 1}, {20, 1}, {10, 2}}
+		index := NewBTree(&data, func(j *Job) int {
+			return j.Deadline
+		}, append(c.opts, WithLocking(mode))...)
+
+		key, pos, ok := index.PeekMin()
+		assert.True(t, ok)
+		assert.Equal(t, 10, key)
+		assert.Equal(t, c.popped[0], pos)
+
+		var popped []int
+		for {
+			_, pos, ok := index.PopMin()
+			if !ok {
+				break
+			}
+			popped = append(popped, pos)
+		}
+		assert.Equal(t, c.popped, popped)
+		_, _, ok = index.PeekMin()
+		assert.False(t, ok)
+		assert.Equal(t, 0, index.Stats().Keys)
+		if mode == SingleWriter {
+			index.Close()
+		}
+	}
+}
+
+func TestBtreeContext(t *testing.T) {
+	type Entity struct {
+		Key int
+	}
+	data := make([]Entity, 5000)
+	for j := range data {
+		data[j].Key = j
+	}
+	index := NewBTree(&data, func(e *Entity) int {
+		return e.Key
+	})
+
+	res, err := index.FindContext(context.Background(), 4990, GTE)
+	assert.NoError(t, err)
+	assert.Len(t, res, 10)
+	res, err = index.GetRangeContext(context.Background(), 10, 20, true, false)
+	assert.NoError(t, err)
+	assert.Len(t, res, 10)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = index.FindContext(ctx, 10, GT)
+	assert.ErrorIs(t, err, context.Canceled)
+	_, err = index.GetRangeContext(ctx, 0, 4000, true, true)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	// the scan is stopped between the chunks of keys
+	ctx, cancel = context.WithCancel(context.Background())
+	visited := 0
+	_, err = index.FindContext(&cancelAfter{Context: ctx, cancel: cancel, n: 2, visited: &visited}, 0, GTE)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 2, visited)
+}
+
+// cancelAfter is a context that is cancelled on the n-th check of Err
+type cancelAfter struct {
+	context.Context
+	cancel  context.CancelFunc
+	n       int
+	visited *int
+}
+
+func (c *cancelAfter) Err() error {
+	if *c.visited++; *c.visited == c.n {
+		c.cancel()
+	}
+	return c.Context.Err()
+}
@@ -0,0 +1,84 @@
+package index
+
+import (
+	"github.com/google/btree"
+)
+
+var _ Index[struct{}] = (*Covering[int, struct{}, int])(nil)
+
+// Covering is a BTree index that also stores a projection of every indexed item
+// next to its data array index in the index nodes, so the lookups can return a few fields
+// of the items without reading the data array.
+// The projections are made by Put, Apply and Rebuild and change together with the postings,
+// so a lookup never returns the projection of another item
+type Covering[T btree.Ordered, A any, P any] struct {
+	*BTree[T, A]
+}
+
+// NewCovering make a covering balanced tree index for the cache data array
+// data is an array of any type data
+// field is a function that returns the field that should be indexed
+// project is a function that returns the projection of the item stored in the index
+// opts are the index options, see NewBTree. It can't be combined with WithPackedPostings
+func NewCovering[T btree.Ordered, A any, P any](
+	data *[]A,
+	field func(cache *A) T,
+	project func(item *A) P,
+	opts ...Option,
+) *Covering[T, A, P] {
+	return &Covering[T, A, P]{
+		BTree: newBTree(data, field, func(item *A) any {
+			return project(item)
+		}, opts),
+	}
+}
+
+// GetProjections returns the projections of the items that match selected key
+func (i *Covering[T, A, P]) GetProjections(key T) []P {
+	return i.projections(opGet, key, EQ)
+}
+
+// FindProjections returns the projections of the items whose keys match key by method.
+// It panics with an error wrapping errs.ErrInvalidSearchMethod if the method is unknown
+func (i *Covering[T, A, P]) FindProjections(key T, method SearchMethod) []P {
+	return i.projections(opFind, key, method)
+}
+
+// projections collects the projections of the nodes visited by the lookup
+func (i *Covering[T, A, P]) projections(op lockOp, key T, method SearchMethod) []P {
+	i.build()
+	if method == EQ && !i.mayHave(key) {
+		return nil
+	}
+	tree, unlock := i.reader(op)
+	defer unlock()
+	var data []P
+	visit(tree, key, method, func(in indexNode[T]) bool {
+		for _, p := range in.projections {
+			data = append(data, p.(P))
+		}
+		return true
+	})
+	return data
+}
+
+// Projection returns the projection of the item of the key stored at the index position of the data array
+func (i *Covering[T, A, P]) Projection(key T, index int) (p P, ok bool) {
+	i.build()
+	if !i.mayHave(key) {
+		return p, false
+	}
+	tree, unlock := i.reader(opGet)
+	defer unlock()
+	in, ok := tree.Get(indexNode[T]{
+		data: key,
+	})
+	if !ok {
+		return p, false
+	}
+	j := positionOf(in.index, index)
+	if j < 0 {
+		return p, false
+	}
+	return in.projections[j].(P), true
+}
@@ -0,0 +1,252 @@
+package index
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/nikk-gr/strmem/errs"
+)
+
+func TestCovering(t *testing.T) {
+	type (
+		Entity struct {
+			ID    int
+			Name  string
+			Group uint32
+		}
+		Short struct {
+			ID   int
+			Name string
+		}
+	)
+	init := func() (*[]Entity, *Covering[uint32, Entity, Short]) {
+		data := &[]Entity{
+			{1, "a", 1},
+			{2, "b", 2},
+			{3, "c", 1},
+			{4, "d", 3},
+		}
+		index := NewCovering(data, func(e *Entity) uint32 {
+			return e.Group
+		}, func(e *Entity) Short {
+			return Short{e.ID, e.Name}
+		})
+		return data, index
+	}
+	byID := func(data []Short) []Short {
+		sort.Slice(data, func(a, b int) bool {
+			return data[a].ID < data[b].ID
+		})
+		return data
+	}
+
+	t.Run("get projections", func(t *testing.T) {
+		_, index := init()
+		assert.Equal(t, []Short{{1, "a"}, {3, "c"}}, byID(index.GetProjections(1)))
+		assert.Nil(t, index.GetProjections(5))
+		assert.Equal(t, []Short{{2, "b"}, {4, "d"}}, byID(index.FindProjections(1, GT)))
+		assert.Equal(t, []Short{{2, "b"}, {4, "d"}}, byID(index.FindProjections(2, GTE)))
+		assert.Equal(t, []Short{{1, "a"}, {3, "c"}}, byID(index.FindProjections(2, LT)))
+		assert.Equal(t, []Short{{1, "a"}, {2, "b"}, {3, "c"}}, byID(index.FindProjections(2, LTE)))
+		assert.Equal(t, []Short{{4, "d"}}, index.FindProjections(3, EQ))
+		assert.Panics(t, func() { index.FindProjections(1, SearchMethod(100)) })
+	})
+
+	t.Run("projections follow mutations", func(t *testing.T) {
+		data, index := init()
+		*data = append(*data, Entity{5, "e", 1})
+		index.Put(&(*data)[4], 4)
+		// swap-delete of the first element
+		index.Rm(&(*data)[0], 0)
+		assert.True(t, index.Reposition(&(*data)[4], 4, 0))
+		(*data)[0] = (*data)[4]
+		*data = (*data)[:4]
+
+		assert.Equal(t, []Short{{3, "c"}, {5, "e"}}, byID(index.GetProjections(1)))
+		p, ok := index.Projection(1, 0)
+		assert.True(t, ok)
+		assert.Equal(t, Short{5, "e"}, p)
+		_, ok = index.Projection(1, 4)
+		assert.False(t, ok)
+		_, ok = index.Projection(5, 0)
+		assert.False(t, ok)
+
+		// repositioning to the same place keeps the projection
+		assert.True(t, index.Reposition(&(*data)[1], 1, 1))
+		assert.NoError(t, index.Apply([]Op[Entity]{
+			{Kind: OpReposition, Item: &(*data)[2], Pos: 2, NewPos: 2},
+		}))
+		p, _ = index.Projection(2, 1)
+		assert.Equal(t, Short{2, "b"}, p)
+		p, _ = index.Projection(1, 2)
+		assert.Equal(t, Short{3, "c"}, p)
+	})
+
+	t.Run("apply and rebuild", func(t *testing.T) {
+		data, index := init()
+		err := index.Apply([]Op[Entity]{
+			{Kind: OpRm, Item: &(*data)[1], Pos: 1},
+			{Kind: OpReposition, Item: &(*data)[3], Pos: 3, NewPos: 1},
+		})
+		assert.NoError(t, err)
+		(*data)[1] = (*data)[3]
+		*data = (*data)[:3]
+		assert.Nil(t, index.GetProjections(2))
+		assert.Equal(t, []Short{{4, "d"}}, index.GetProjections(3))
+
+		(*data)[1].Name = "x"
+		index.Rebuild()
+		assert.Equal(t, []Short{{4, "x"}}, index.GetProjections(3))
+	})
+
+	t.Run("every rebuild rebuilds projections", func(t *testing.T) {
+		data, index := init()
+		(*data)[0].Name = "x"
+		assert.NoError(t, index.RebuildContext(context.Background()))
+		assert.Equal(t, []Short{{1, "x"}, {3, "c"}}, byID(index.GetProjections(1)))
+
+		(*data)[0].Name = "y"
+		assert.NoError(t, <-index.RebuildAsync(context.Background()))
+		assert.Equal(t, []Short{{1, "y"}, {3, "c"}}, byID(index.GetProjections(1)))
+
+		// a cancelled rebuild keeps the projections
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		(*data)[0].Name = "z"
+		assert.ErrorIs(t, index.RebuildContext(ctx), context.Canceled)
+		assert.Equal(t, []Short{{1, "y"}, {3, "c"}}, byID(index.GetProjections(1)))
+	})
+
+	t.Run("panicking projection", func(t *testing.T) {
+		data := &[]Entity{{1, "a", 1}, {2, "b", 2}}
+		broken := false
+		index := NewCovering(data, func(e *Entity) uint32 {
+			return e.Group
+		}, func(e *Entity) Short {
+			if broken {
+				panic("broken projection")
+			}
+			return Short{e.ID, e.Name}
+		})
+		(*data)[0].Group = 2
+		broken = true
+
+		// the old tree and projections are kept
+		assert.True(t, errors.Is(index.RebuildContext(context.Background()), errs.ErrPanic))
+		assert.Equal(t, []Short{{1, "a"}}, index.GetProjections(1))
+
+		item := Entity{3, "c", 3}
+		err := index.Apply([]Op[Entity]{
+			{Kind: OpRm, Item: &Entity{1, "a", 1}, Pos: 0},
+			{Kind: OpPut, Item: &item, Pos: 2},
+		})
+		assert.True(t, errors.Is(err, errs.ErrPanic))
+		assert.Equal(t, []Short{{1, "a"}}, index.GetProjections(1))
+		assert.Nil(t, index.Get(3))
+	})
+
+	t.Run("lazy build and enable build projections", func(t *testing.T) {
+		data := &[]Entity{{1, "a", 1}, {2, "b", 2}}
+		project := func(e *Entity) Short {
+			return Short{e.ID, e.Name}
+		}
+		lazy := NewCovering(data, func(e *Entity) uint32 {
+			return e.Group
+		}, project, WithLazyBuild())
+		disabled := NewCovering(data, func(e *Entity) uint32 {
+			return e.Group
+		}, project)
+		disabled.Disable()
+		(*data)[0].Name = "x"
+		assert.Equal(t, []Short{{1, "x"}}, lazy.GetProjections(1))
+		disabled.Enable()
+		assert.Equal(t, []Short{{1, "x"}}, disabled.GetProjections(1))
+	})
+	t.Run("pop min", func(t *testing.T) {
+		_, index := init()
+		key, pos, ok := index.PopMin()
+		assert.True(t, ok)
+		assert.Equal(t, uint32(1), key)
+		assert.Equal(t, 0, pos)
+		_, ok = index.Projection(1, 0)
+		assert.False(t, ok)
+		assert.Equal(t, []Short{{3, "c"}}, index.GetProjections(1))
+	})
+
+	t.Run("posting order keeps projections next to postings", func(t *testing.T) {
+		data := &[]Entity{{3, "c", 1}, {1, "a", 1}, {2, "b", 1}}
+		index := NewCovering(data, func(e *Entity) uint32 {
+			return e.Group
+		}, func(e *Entity) Short {
+			return Short{e.ID, e.Name}
+		}, WithPostingOrder(func(x, y *Entity) bool {
+			return x.ID < y.ID
+		}))
+		assert.Equal(t, []int{1, 2, 0}, index.Get(1))
+		assert.Equal(t, []Short{{1, "a"}, {2, "b"}, {3, "c"}}, index.GetProjections(1))
+
+		*data = append(*data, Entity{0, "z", 1})
+		index.Put(&(*data)[3], 3)
+		assert.Equal(t, []Short{{0, "z"}, {1, "a"}, {2, "b"}, {3, "c"}}, index.GetProjections(1))
+		index.Rm(&(*data)[2], 2)
+		assert.Equal(t, []Short{{0, "z"}, {1, "a"}, {3, "c"}}, index.GetProjections(1))
+	})
+
+	t.Run("packed postings", func(t *testing.T) {
+		data := &[]Entity{{1, "a", 1}}
+		assert.Panics(t, func() {
+			NewCovering(data, func(e *Entity) uint32 {
+				return e.Group
+			}, func(e *Entity) Short {
+				return Short{e.ID, e.Name}
+			}, WithPackedPostings(2))
+		})
+	})
+
+	t.Run("mutations during rebuild keep projections", func(t *testing.T) {
+		data := make([]Entity, 3000)
+		for j := range data {
+			data[j] = Entity{ID: j, Group: uint32(j)}
+		}
+		var (
+			paused = make(chan struct{})
+			resume = make(chan struct{})
+			once   sync.Once
+		)
+		index := NewCovering(&data, func(e *Entity) uint32 {
+			if e.Name == "pause" {
+				once.Do(func() {
+					paused <- struct{}{}
+					<-resume
+				})
+			}
+			return e.Group
+		}, func(e *Entity) Short {
+			return Short{e.ID, e.Name}
+		})
+		data[2000].Name = "pause"
+		rebuilt := index.RebuildAsync(context.Background())
+		<-paused
+
+		// swap-delete of an item the build has already read, the moved one is not read yet
+		extra := Entity{ID: 3000, Name: "extra", Group: 5000}
+		index.Put(&extra, 3000)
+		index.Rm(&data[10], 10)
+		assert.True(t, index.Reposition(&data[2999], 2999, 10))
+		data[10] = data[2999]
+		close(resume)
+		assert.NoError(t, <-rebuilt)
+
+		assert.Equal(t, []Short{{3000, "extra"}}, index.GetProjections(5000))
+		assert.Equal(t, []Short{{2999, ""}}, index.GetProjections(2999))
+		p, ok := index.Projection(2999, 10)
+		assert.True(t, ok)
+		assert.Equal(t, Short{2999, ""}, p)
+		assert.Nil(t, index.GetProjections(10))
+	})
+}
@@ -0,0 +1,143 @@
+package index
+
+import (
+	"hash/maphash"
+	"math"
+	"math/bits"
+
+	"github.com/google/btree"
+)
+
+// distinctPrecision is the number of hash bits that select a HyperLogLog register.
+// 2^14 registers give about 0.8% standard error
+const distinctPrecision = 14
+
+var _ Index[struct{}] = (*Distinct[int, struct{}])(nil)
+
+// Distinct estimates the number of distinct values of a field by HyperLogLog
+// without keeping the values or the data array indexes.
+// Values are never removed from the estimate, Rm doesn't change it until the next Rebuild
+type Distinct[T btree.Ordered, A any] struct {
+	locker
+	dataPtr   *[]A
+	getField  func(cache *A) T
+	predicate func(item *A) bool
+	seed      maphash.Seed
+	registers []uint8
+}
+
+// NewDistinct make a distinct value estimator for the field of the cache data array
+// data is an array of any type data
+// field is a function that returns the field whose distinct values are counted
+// opts are the index options, WithLocking and WithPredicate are supported. SingleWriter works as RWLock
+func NewDistinct[T btree.Ordered, A any](
+	data *[]A,
+	field func(cache *A) T,
+	opts ...Option,
+) *Distinct[T, A] {
+	o := newOptions(opts)
+	ind := Distinct[T, A]{
+		dataPtr:   data,
+		getField:  field,
+		predicate: predicateFor[A](o),
+		seed:      maphash.MakeSeed(),
+	}
+	ind.locker.disabled = o.lockMode == NoLock
+	ind.Rebuild()
+	return &ind
+}
+
+// EstimateDistinct returns the estimated number of distinct values of the field
+func (i *Distinct[T, A]) EstimateDistinct() uint64 {
+	defer i.rlock(opGet)()
+	m := float64(len(i.registers))
+	var (
+		sum   float64
+		zeros int
+	)
+	for _, r := range i.registers {
+		sum += 1 / float64(uint64(1)<<r)
+		if r == 0 {
+			zeros++
+		}
+	}
+	estimate := 0.7213 / (1 + 1.079/m) * m * m / sum
+	if estimate <= 2.5*m && zeros > 0 {
+		// linear counting is more precise for small cardinalities
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return uint64(math.Round(estimate))
+}
+
+// Put adds the field value of the item to the estimate
+func (i *Distinct[T, A]) Put(item *A, index int) {
+	if i.predicate != nil && !i.predicate(item) {
+		return
+	}
+	h := hashKey(i.seed, i.getField(item))
+	defer i.lock(opPut)()
+	add(i.registers, h)
+}
+
+// Rm does nothing, a HyperLogLog can't forget values
+func (i *Distinct[T, A]) Rm(item *A, index int) {}
+
+// Reposition does nothing, the estimate doesn't depend on the data array indexes
+func (i *Distinct[T, A]) Reposition(item *A, oldPos, newPos int) bool {
+	return true
+}
+
+// Apply adds the field values of OpPut operations to the estimate
+func (i *Distinct[T, A]) Apply(ops []Op[A]) error {
+	for _, op := range ops {
+		if op.Kind == OpPut {
+			i.Put(op.Item, op.Pos)
+		}
+	}
+	return nil
+}
+
+// Rebuild drops the estimate and counts the data array again.
+// The data array is counted without the lock. If the field extractor or the predicate panics,
+// the old estimate is kept and Rebuild panics with *PanicError
+func (i *Distinct[T, A]) Rebuild() {
+	registers, err := i.count()
+	if err != nil {
+		panic(err)
+	}
+	defer i.lock(opRebuild)()
+	i.registers = registers
+}
+
+// count returns the registers of the data array.
+// A panic of the user-provided functions is returned as *PanicError
+func (i *Distinct[T, A]) count() (_ []uint8, err error) {
+	defer recoverTo(&err)
+	registers := make([]uint8, 1<<distinctPrecision)
+	for j := range *i.dataPtr {
+		if i.predicate != nil && !i.predicate(&(*i.dataPtr)[j]) {
+			continue
+		}
+		add(registers, hashKey(i.seed, i.getField(&(*i.dataPtr)[j])))
+	}
+	return registers, nil
+}
+
+// add puts the value hash into the registers, the lock must be held if they are shared
+func add(registers []uint8, h uint64) {
+	register := h >> (64 - distinctPrecision)
+	rank := uint8(bits.LeadingZeros64(h<<distinctPrecision|1<<(distinctPrecision-1))) + 1
+	if rank > registers[register] {
+		registers[register] = rank
+	}
+}
+
+// Stats returns the estimated number of distinct values as Keys and the memory used by the registers
+func (i *Distinct[T, A]) Stats() Stats {
+	keys := i.EstimateDistinct()
+	defer i.rlock(opStats)()
+	return Stats{
+		Keys:  int(keys),
+		Bytes: uintptr(len(i.registers)),
+	}
+}
@@ -0,0 +1,87 @@
+package index
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/nikk-gr/strmem/errs"
+)
+
+func TestDistinct(t *testing.T) {
+	type Entity struct {
+		Key  int
+		Name string
+	}
+	data := make([]Entity, 50000)
+	for j := range data {
+		data[j].Key = j % 20000
+		data[j].Name = fmt.Sprint("name", j%10)
+	}
+
+	t.Run("large cardinality", func(t *testing.T) {
+		estimator := NewDistinct(&data, func(e *Entity) int {
+			return e.Key
+		})
+		assert.InEpsilon(t, 20000, estimator.EstimateDistinct(), 0.03)
+	})
+
+	t.Run("small cardinality and put", func(t *testing.T) {
+		estimator := NewDistinct(&data, func(e *Entity) string {
+			return e.Name
+		})
+		// the seed is random, so a collision of two registers can lower the estimate by one
+		before := estimator.EstimateDistinct()
+		assert.InDelta(t, 10, before, 1)
+		data = append(data, Entity{Name: "other"})
+		estimator.Put(&data[len(data)-1], len(data)-1)
+		after := estimator.EstimateDistinct()
+		assert.InDelta(t, 11, after, 1)
+		assert.GreaterOrEqual(t, after, before)
+		assert.Equal(t, int(after), estimator.Stats().Keys)
+	})
+
+	t.Run("single writer works as rwlock", func(t *testing.T) {
+		estimator := NewDistinct(&data, func(e *Entity) string {
+			return e.Name
+		}, WithLocking(SingleWriter))
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				estimator.Put(&Entity{Name: fmt.Sprint("new", j)}, 0)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				estimator.EstimateDistinct()
+			}
+		}()
+		wg.Wait()
+		assert.NotZero(t, estimator.LockStats().Put.Count)
+	})
+
+	t.Run("panicking rebuild keeps the estimate", func(t *testing.T) {
+		small := []Entity{{1, "a"}, {2, "b"}}
+		broken := false
+		estimator := NewDistinct(&small, func(e *Entity) int {
+			if broken {
+				panic("broken extractor")
+			}
+			return e.Key
+		})
+		before := estimator.EstimateDistinct()
+		broken = true
+		defer func() {
+			err, _ := recover().(error)
+			assert.True(t, errors.Is(err, errs.ErrPanic))
+			assert.Equal(t, before, estimator.EstimateDistinct())
+		}()
+		estimator.Rebuild()
+	})
+}
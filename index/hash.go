@@ -0,0 +1,444 @@
+package index
+
+import (
+	"fmt"
+	"hash/maphash"
+	"sort"
+	"sync"
+	"unsafe"
+
+	"github.com/google/btree"
+
+	"github.com/nikk-gr/strmem/errs"
+)
+
+// defaultStripes is the number of lock stripes of HashIndex used when WithLockStripes is not set
+const defaultStripes = 64
+
+var _ Index[struct{}] = (*HashIndex[int, struct{}])(nil)
+var _ Equality[int] = (*HashIndex[int, struct{}])(nil)
+
+// hashStripe is a part of HashIndex guarded by its own lock
+type hashStripe[T btree.Ordered] struct {
+	mu   sync.RWMutex
+	keys map[T][]int
+	// the padding keeps the locks of the neighbouring stripes in different cache lines
+	_ [64]byte
+}
+
+// HashIndex is an index for the equality lookups.
+// The keys are spread over the stripes by hash and every stripe has its own lock,
+// so Get, Put and Rm of the keys in different stripes never wait for each other.
+// Apply and Rebuild take the locks of all stripes.
+// Mutations wait for a running Rebuild, so none of them is lost by the swap of the rebuilt stripes,
+// and are applied idempotently after it.
+// Lock statistics are not collected
+type HashIndex[T btree.Ordered, A any] struct {
+	dataPtr   *[]A
+	getField  func(cache *A) T
+	sorted    bool
+	predicate func(item *A) bool
+	noLock    bool
+	seed      maphash.Seed
+	stripes   []hashStripe[T]
+	lazy      lazyBuild
+	// gate is read locked by the mutations and write locked by Rebuild for the whole build,
+	// it is taken before the stripe locks
+	gate sync.RWMutex
+}
+
+// NewHashIndex make a hash index for the field of the cache data array
+// data is an array of any type data
+// field is a function that returns the field that should be indexed
+// opts are the index options, WithLocking, WithSortedResults, WithPredicate, WithLazyBuild
+// and WithLockStripes are supported. SingleWriter works as RWLock
+func NewHashIndex[T btree.Ordered, A any](
+	data *[]A,
+	field func(cache *A) T,
+	opts ...Option,
+) *HashIndex[T, A] {
+	o := newOptions(opts)
+	stripes := o.stripes
+	if stripes == 0 {
+		stripes = defaultStripes
+	}
+	ind := HashIndex[T, A]{
+		dataPtr:   data,
+		getField:  field,
+		sorted:    o.sorted,
+		predicate: predicateFor[A](o),
+		noLock:    o.lockMode == NoLock,
+		seed:      maphash.MakeSeed(),
+		stripes:   make([]hashStripe[T], stripes),
+	}
+	for j := range ind.stripes {
+		ind.stripes[j].keys = map[T][]int{}
+	}
+	if !o.lazy {
+		ind.Rebuild()
+	}
+	return &ind
+}
+
+// stripe returns the stripe of the key
+func (i *HashIndex[T, A]) stripe(key T) *hashStripe[T] {
+	return &i.stripes[hashKey(i.seed, key)%uint64(len(i.stripes))]
+}
+
+// rlock takes the read lock of the stripe and returns the function that releases it
+func (i *HashIndex[T, A]) rlock(s *hashStripe[T]) (unlock func()) {
+	if i.noLock {
+		return noop
+	}
+	s.mu.RLock()
+	return s.mu.RUnlock
+}
+
+// lock takes the write lock of the stripe and returns the function that releases it
+func (i *HashIndex[T, A]) lock(s *hashStripe[T]) (unlock func()) {
+	if i.noLock {
+		return noop
+	}
+	s.mu.Lock()
+	return s.mu.Unlock
+}
+
+// mutation takes the read lock of the gate and returns the function that releases it.
+// waited reports whether the mutation waited for Rebuild, which could already read the change
+// from the data array, so such a mutation must be applied idempotently
+func (i *HashIndex[T, A]) mutation() (unlock func(), waited bool) {
+	if i.noLock {
+		return noop, false
+	}
+	if !i.gate.TryRLock() {
+		testHookWait()
+		i.gate.RLock()
+		waited = true
+	}
+	return i.gate.RUnlock, waited
+}
+
+// lockAll takes the write locks of all stripes in order and returns the function that releases them
+func (i *HashIndex[T, A]) lockAll() (unlock func()) {
+	if i.noLock {
+		return noop
+	}
+	for j := range i.stripes {
+		i.stripes[j].mu.Lock()
+	}
+	return func() {
+		for j := range i.stripes {
+			i.stripes[j].mu.Unlock()
+		}
+	}
+}
+
+// Rebuild removes the old index and builds new.
+// Mutations wait until the new stripes replace the old ones, Get uses the old ones meanwhile
+func (i *HashIndex[T, A]) Rebuild() {
+	// the build of an index that isn't built yet holds the lock of the lazy build,
+	// so the mutations wait for it instead of being skipped as pending
+	i.lazy.mu.Lock()
+	defer i.lazy.mu.Unlock()
+	i.rebuild()
+}
+
+// rebuild is Rebuild for callers that hold the lock of the lazy build
+func (i *HashIndex[T, A]) rebuild() {
+	if !i.noLock {
+		i.gate.Lock()
+		defer i.gate.Unlock()
+	}
+	keys := make([]map[T][]int, len(i.stripes))
+	for j := range keys {
+		keys[j] = map[T][]int{}
+	}
+	for j := range *i.dataPtr {
+		if !i.accepts(&(*i.dataPtr)[j]) {
+			continue
+		}
+		key := i.getField(&(*i.dataPtr)[j])
+		s := hashKey(i.seed, key) % uint64(len(i.stripes))
+		keys[s][key] = append(keys[s][key], j)
+	}
+	defer i.lockAll()()
+	for j := range i.stripes {
+		i.stripes[j].keys = keys[j]
+	}
+	i.lazy.built.Store(true)
+}
+
+// Get returns the slice of data array indexes that match selected key
+func (i *HashIndex[T, A]) Get(key T) []int {
+	i.lazy.ensure(i.rebuild)
+	s := i.stripe(key)
+	defer i.rlock(s)()
+	data := s.keys[key]
+	if !i.sorted || data == nil {
+		return data
+	}
+	data = append([]int(nil), data...)
+	sort.Ints(data)
+	return data
+}
+
+// Contains reports whether the key has the data array index
+func (i *HashIndex[T, A]) Contains(key T, index int) bool {
+	i.lazy.ensure(i.rebuild)
+	s := i.stripe(key)
+	defer i.rlock(s)()
+	return s.has(key, index)
+}
+
+// Put adds the item stored at the index position of the data array
+func (i *HashIndex[T, A]) Put(item *A, index int) {
+	pending, waited := i.lazy.pending()
+	if pending || !i.accepts(item) {
+		return
+	}
+	key := i.getField(item)
+	s := i.stripe(key)
+	unlock, rebuilt := i.mutation()
+	defer unlock()
+	defer i.lock(s)()
+	if (waited || rebuilt) && s.has(key, index) {
+		return
+	}
+	s.put(key, index)
+}
+
+// put adds the data array index to the key, the stripe lock must be held
+func (s *hashStripe[T]) put(key T, index int) {
+	// append doesn't change the part of the slice seen by the readers
+	s.keys[key] = append(s.keys[key], index)
+}
+
+// Rm removes the item stored at the index position of the data array.
+// Nothing is removed if the key of the item doesn't have this position
+func (i *HashIndex[T, A]) Rm(item *A, index int) {
+	// rm is idempotent, so a removal that waited for a build is applied as is
+	if pending, _ := i.lazy.pending(); pending || !i.accepts(item) {
+		return
+	}
+	key := i.getField(item)
+	s := i.stripe(key)
+	unlock, _ := i.mutation()
+	defer unlock()
+	defer i.lock(s)()
+	s.rm(key, index)
+}
+
+// rm removes the data array index from the key and reports whether the key had it.
+// The stripe lock must be held
+func (s *hashStripe[T]) rm(key T, index int) bool {
+	postings, ok := s.keys[key]
+	if !ok {
+		return false
+	}
+	// the posting slice could be returned by Get, so it is never changed in place
+	res := rmFromArr(append([]int(nil), postings...), index)
+	switch {
+	case len(res) == len(postings):
+		return false
+	case len(res) == 0:
+		delete(s.keys, key)
+	default:
+		s.keys[key] = res
+	}
+	return true
+}
+
+// Reposition moves the item from oldPos to newPos of the data array, e.g. after a swap-delete.
+// It reports whether the key of the item had oldPos
+func (i *HashIndex[T, A]) Reposition(item *A, oldPos, newPos int) bool {
+	if !i.accepts(item) {
+		return false
+	}
+	pending, waited := i.lazy.pending()
+	if pending {
+		return true
+	}
+	key := i.getField(item)
+	s := i.stripe(key)
+	unlock, rebuilt := i.mutation()
+	defer unlock()
+	defer i.lock(s)()
+	if waited || rebuilt {
+		s.move(key, oldPos, newPos)
+		return true
+	}
+	return s.reposition(key, oldPos, newPos)
+}
+
+// has reports whether the key has the data array index, the stripe lock must be held
+func (s *hashStripe[T]) has(key T, index int) bool {
+	for _, pos := range s.keys[key] {
+		if pos == index {
+			return true
+		}
+	}
+	return false
+}
+
+// move is the idempotent reposition for a mutation that waited for a build,
+// which could already read the item at newPos. The stripe lock must be held
+func (s *hashStripe[T]) move(key T, oldPos, newPos int) {
+	s.rm(key, oldPos)
+	if !s.has(key, newPos) {
+		s.put(key, newPos)
+	}
+}
+
+// reposition replaces oldPos of the key by newPos and reports whether the key had oldPos.
+// The stripe lock must be held
+func (s *hashStripe[T]) reposition(key T, oldPos, newPos int) bool {
+	postings := s.keys[key]
+	for j := range postings {
+		if postings[j] == oldPos {
+			postings = append([]int(nil), postings...)
+			postings[j] = newPos
+			s.keys[key] = postings
+			return true
+		}
+	}
+	return false
+}
+
+// Apply executes the batch of operations under the locks of all stripes.
+// If one of the operations fails, the applied ones are reverted and the error wraps errs.ErrNotFound.
+// The keys are computed before any stripe is changed, so a panic of the user-provided functions
+// changes nothing and is returned as *PanicError
+func (i *HashIndex[T, A]) Apply(ops []Op[A]) error {
+	pending, waited := i.lazy.pending()
+	if pending {
+		return nil
+	}
+	keyed, err := i.keyed(ops)
+	if err != nil {
+		return err
+	}
+	unlock, rebuilt := i.mutation()
+	defer unlock()
+	defer i.lockAll()()
+	if waited || rebuilt {
+		// the build could already read some of the operations, so they are applied idempotently
+		for _, op := range keyed {
+			i.applyIdempotent(op)
+		}
+		return nil
+	}
+	for j, op := range keyed {
+		if err := i.applyOp(op); err != nil {
+			for k := j - 1; k >= 0; k-- {
+				i.revertOp(keyed[k])
+			}
+			return fmt.Errorf("op %d: %w", j, err)
+		}
+	}
+	return nil
+}
+
+// keyedOp is a batch operation with the key of its item
+type keyedOp[T btree.Ordered] struct {
+	kind   OpKind
+	key    T
+	pos    int
+	newPos int
+	// skip is set if the item doesn't pass the index predicate
+	skip bool
+}
+
+// keyed returns the batch operations with the keys of their items.
+// A panic of the user-provided functions is returned as *PanicError
+func (i *HashIndex[T, A]) keyed(ops []Op[A]) (_ []keyedOp[T], err error) {
+	defer recoverTo(&err)
+	keyed := make([]keyedOp[T], len(ops))
+	for j, op := range ops {
+		keyed[j] = keyedOp[T]{kind: op.Kind, pos: op.Pos, newPos: op.NewPos, skip: !i.accepts(op.Item)}
+		if !keyed[j].skip {
+			keyed[j].key = i.getField(op.Item)
+		}
+	}
+	return keyed, nil
+}
+
+// applyOp executes one batch operation, the locks of all stripes must be held
+func (i *HashIndex[T, A]) applyOp(op keyedOp[T]) error {
+	if op.skip {
+		return nil
+	}
+	s := i.stripe(op.key)
+	switch op.kind {
+	case OpPut:
+		s.put(op.key, op.pos)
+	case OpRm:
+		if !s.rm(op.key, op.pos) {
+			return fmt.Errorf("rm position %d: %w", op.pos, errs.ErrNotFound)
+		}
+	case OpReposition:
+		if !s.reposition(op.key, op.pos, op.newPos) {
+			return fmt.Errorf("reposition %d: %w", op.pos, errs.ErrNotFound)
+		}
+	default:
+		return fmt.Errorf("invalid op kind %d", op.kind)
+	}
+	return nil
+}
+
+// applyIdempotent executes one operation of the batch that waited for a build.
+// The locks of all stripes must be held
+func (i *HashIndex[T, A]) applyIdempotent(op keyedOp[T]) {
+	if op.skip {
+		return
+	}
+	s := i.stripe(op.key)
+	switch op.kind {
+	case OpPut:
+		if !s.has(op.key, op.pos) {
+			s.put(op.key, op.pos)
+		}
+	case OpRm:
+		s.rm(op.key, op.pos)
+	case OpReposition:
+		s.move(op.key, op.pos, op.newPos)
+	}
+}
+
+// revertOp undoes the operation executed by applyOp, the locks of all stripes must be held
+func (i *HashIndex[T, A]) revertOp(op keyedOp[T]) {
+	if op.skip {
+		return
+	}
+	s := i.stripe(op.key)
+	switch op.kind {
+	case OpPut:
+		s.rm(op.key, op.pos)
+	case OpRm:
+		s.put(op.key, op.pos)
+	case OpReposition:
+		s.reposition(op.key, op.newPos, op.pos)
+	}
+}
+
+// accepts reports whether the item passes the index predicate
+func (i *HashIndex[T, A]) accepts(item *A) bool {
+	return i.predicate == nil || i.predicate(item)
+}
+
+// Stats returns the number of keys and postings in the index and its memory estimate
+func (i *HashIndex[T, A]) Stats() (s Stats) {
+	var key T
+	for j := range i.stripes {
+		stripe := &i.stripes[j]
+		unlock := i.rlock(stripe)
+		s.Keys += len(stripe.keys)
+		for _, postings := range stripe.keys {
+			s.Postings += len(postings)
+			s.Bytes += uintptr(cap(postings)) * postingSize
+		}
+		unlock()
+	}
+	s.Bytes += uintptr(s.Keys)*(unsafe.Sizeof(key)+unsafe.Sizeof([]int(nil))) +
+		uintptr(len(i.stripes))*unsafe.Sizeof(hashStripe[T]{})
+	return s
+}
@@ -0,0 +1,187 @@
+package index
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/nikk-gr/strmem/errs"
+)
+
+func TestHashIndex(t *testing.T) {
+	type Entity struct {
+		Key    string
+		Active bool
+	}
+	init := func() *[]Entity {
+		return &[]Entity{
+			{"a", true},
+			{"b", true},
+			{"a", false},
+			{"c", true},
+			{"a", true},
+		}
+	}
+	field := func(e *Entity) string {
+		return e.Key
+	}
+
+	t.Run("get", func(t *testing.T) {
+		index := NewHashIndex(init(), field, WithSortedResults())
+		assert.Equal(t, []int{0, 2, 4}, index.Get("a"))
+		assert.Nil(t, index.Get("d"))
+		assert.True(t, index.Contains("c", 3))
+		assert.False(t, index.Contains("c", 1))
+		assert.Equal(t, Stats{Keys: 3, Postings: 5, Bytes: index.Stats().Bytes}, index.Stats())
+	})
+
+	t.Run("put, rm and reposition", func(t *testing.T) {
+		data := init()
+		index := NewHashIndex(data, field, WithSortedResults(), WithLockStripes(1))
+		*data = append(*data, Entity{"d", true})
+		index.Put(&(*data)[5], 5)
+		assert.Equal(t, []int{5}, index.Get("d"))
+
+		index.Rm(&(*data)[0], 0)
+		assert.True(t, index.Reposition(&(*data)[5], 5, 0))
+		assert.False(t, index.Reposition(&(*data)[5], 5, 0))
+		(*data)[0], *data = (*data)[5], (*data)[:5]
+		assert.Equal(t, []int{2, 4}, index.Get("a"))
+		assert.Equal(t, []int{0}, index.Get("d"))
+
+		index.Rm(&(*data)[0], 0)
+		assert.Nil(t, index.Get("d"))
+		assert.Equal(t, 3, index.Stats().Keys)
+	})
+
+	t.Run("apply", func(t *testing.T) {
+		data := init()
+		index := NewHashIndex(data, field, WithSortedResults())
+		item := Entity{"b", true}
+		err := index.Apply([]Op[Entity]{
+			{Kind: OpPut, Item: &item, Pos: 5},
+			{Kind: OpReposition, Item: &(*data)[0], Pos: 0, NewPos: 6},
+			{Kind: OpRm, Item: &(*data)[3], Pos: 1},
+		})
+		assert.True(t, errors.Is(err, errs.ErrNotFound))
+		assert.Equal(t, []int{1}, index.Get("b"))
+		assert.Equal(t, []int{0, 2, 4}, index.Get("a"))
+
+		assert.NoError(t, index.Apply([]Op[Entity]{
+			{Kind: OpPut, Item: &item, Pos: 5},
+			{Kind: OpRm, Item: &(*data)[1], Pos: 1},
+		}))
+		assert.Equal(t, []int{5}, index.Get("b"))
+	})
+
+	t.Run("apply with panicking extractor", func(t *testing.T) {
+		data := init()
+		index := NewHashIndex(data, func(e *Entity) string {
+			if e.Key == "broken" {
+				panic("broken extractor")
+			}
+			return e.Key
+		}, WithSortedResults())
+		err := index.Apply([]Op[Entity]{
+			{Kind: OpRm, Item: &(*data)[0], Pos: 0},
+			{Kind: OpPut, Item: &Entity{"broken", true}, Pos: 5},
+		})
+		var panicErr *PanicError
+		if assert.True(t, errors.As(err, &panicErr)) {
+			assert.Equal(t, "broken extractor", panicErr.Value)
+		}
+		assert.Equal(t, []int{0, 2, 4}, index.Get("a"))
+	})
+
+	t.Run("predicate and lazy build", func(t *testing.T) {
+		data := init()
+		index := NewHashIndex(data, field, WithSortedResults(), WithLazyBuild(), WithPredicate(func(e *Entity) bool {
+			return e.Active
+		}))
+		(*data)[1].Key = "a"
+		assert.Equal(t, []int{0, 1, 4}, index.Get("a"))
+	})
+
+	t.Run("invalid stripes", func(t *testing.T) {
+		assert.Panics(t, func() {
+			NewHashIndex(init(), field, WithLockStripes(0))
+		})
+	})
+}
+
+func TestHashIndexRebuildWithMutations(t *testing.T) {
+	type Entity struct {
+		Key int
+	}
+	data := make([]Entity, 10)
+	for j := range data {
+		data[j].Key = j
+	}
+	var (
+		pause   atomic.Bool
+		paused  = make(chan struct{})
+		resume  = make(chan struct{})
+		mutated = make(chan struct{})
+	)
+	index := NewHashIndex(&data, func(e *Entity) int {
+		if e.Key == 5 && pause.CompareAndSwap(true, false) {
+			close(paused)
+			<-resume
+		}
+		return e.Key
+	})
+
+	pause.Store(true)
+	waits := hookWaits(t)
+	go func() {
+		<-paused
+		go func() {
+			defer close(mutated)
+			// the build has already read the item
+			index.Put(&data[1], 1)
+			// the item is not in the data array, so only the mutation can add it
+			extra := Entity{Key: 3}
+			index.Put(&extra, 20)
+		}()
+		<-waits
+		close(resume)
+	}()
+	index.Rebuild()
+	<-mutated
+
+	assert.Equal(t, []int{3, 20}, index.Get(3))
+	assert.Equal(t, []int{1}, index.Get(1))
+}
+
+func TestHashIndexConcurrent(t *testing.T) {
+	type Entity struct {
+		Key int
+	}
+	const writers, keys = 8, 100
+	data := make([]Entity, writers*keys)
+	for j := range data {
+		data[j].Key = j
+	}
+	index := NewHashIndex(&data, func(e *Entity) int {
+		return e.Key
+	})
+
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for j := w * keys; j < (w+1)*keys; j++ {
+				index.Rm(&data[j], j)
+				assert.Nil(t, index.Get(j))
+				index.Put(&data[j], j)
+				assert.Equal(t, []int{j}, index.Get(j))
+			}
+		}(w)
+	}
+	wg.Wait()
+	assert.Equal(t, writers*keys, index.Stats().Postings)
+}
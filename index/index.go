@@ -0,0 +1,83 @@
+package index
+
+import "unsafe"
+
+// Index is an index of the cache data array.
+// The collection layer keeps every Index in sync with the data array through Put and Rm
+type Index[A any] interface {
+	// Put adds the item stored at the index position of the data array
+	Put(item *A, index int)
+	// Rm removes the item stored at the index position of the data array
+	Rm(item *A, index int)
+	// Reposition moves the item from oldPos to newPos of the data array
+	Reposition(item *A, oldPos, newPos int) bool
+	// Apply executes the batch of operations, either all of them or none
+	Apply(ops []Op[A]) error
+	// Rebuild removes the old index and builds new from the data array
+	Rebuild()
+	// Stats returns the size of the index
+	Stats() Stats
+}
+
+// OpKind is a type of the batch operation
+type OpKind uint8
+
+const (
+	// OpPut is Put of the Item at Pos
+	OpPut OpKind = iota
+	// OpRm is Rm of the Item at Pos
+	OpRm
+	// OpReposition is Reposition of the Item from Pos to NewPos
+	OpReposition
+)
+
+// Op is an operation of the Index.Apply batch
+type Op[A any] struct {
+	Kind   OpKind
+	Item   *A
+	Pos    int
+	NewPos int
+}
+
+// Equality is an index that can look up the data array positions by key
+type Equality[T any] interface {
+	// Get returns the slice of data array indexes that match selected key
+	Get(key T) []int
+}
+
+// Ordered is an index that keeps the keys sorted and can look up key ranges
+type Ordered[T any] interface {
+	Equality[T]
+	// Find returns the slice of data array indexes whose keys match key by method
+	Find(key T, method SearchMethod) []int
+	// GetRange returns the slice of data array indexes whose keys are between from and to
+	GetRange(from, to T, includeFrom, includeTo bool) []int
+}
+
+// Spatial is an index that can look up the data by location.
+// P is a point type defined by the implementation
+type Spatial[P any] interface {
+	// Within returns the slice of data array indexes located in the box between min and max
+	Within(min, max P) []int
+	// Nearest returns the slice of up to n data array indexes closest to point
+	Nearest(point P, n int) []int
+}
+
+// FullText is an index that can look up the data by text query
+type FullText interface {
+	// Search returns the slice of data array indexes that match the query
+	Search(query string) []int
+}
+
+// Stats is a size summary of an index
+type Stats struct {
+	// Keys is the number of distinct keys
+	Keys int
+	// Postings is the number of data array indexes stored for all keys
+	Postings int
+	// Bytes is an estimate of the memory used by the index
+	Bytes uintptr
+}
+
+// postingSize is the memory used by one data array index in a posting list
+const postingSize = unsafe.Sizeof(int(0))
@@ -0,0 +1,46 @@
+package index
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// testHookWait is called by a mutation before it takes a lock that a running build could hold.
+// Tests replace it to know that the mutation waits for the build without sleeping
+var testHookWait = func() {}
+
+// lazyBuild builds an index on its first query, see WithLazyBuild
+type lazyBuild struct {
+	mu    sync.Mutex
+	built atomic.Bool
+}
+
+// ensure runs build if the index isn't built yet. Concurrent callers wait for the one build
+func (l *lazyBuild) ensure(build func()) {
+	if l.built.Load() {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.built.Load() {
+		build()
+		l.built.Store(true)
+	}
+}
+
+// pending reports whether the index isn't built yet, so a mutation can be skipped:
+// the first build reads the data array with the change.
+// waited reports whether the mutation waited for a running build. The build could read
+// the change from the data array or miss it, so such a mutation must be applied idempotently
+func (l *lazyBuild) pending() (pending, waited bool) {
+	if l.built.Load() {
+		return false, false
+	}
+	testHookWait()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.built.Load() {
+		return true, false
+	}
+	return false, true
+}
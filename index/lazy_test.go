@@ -0,0 +1,222 @@
+package index
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLazyBuild(t *testing.T) {
+	type (
+		Entity struct {
+			Key int
+		}
+		lookup interface {
+			Index[Entity]
+			Equality[int]
+		}
+	)
+	field := func(e *Entity) int {
+		return e.Key
+	}
+
+	t.Run("btree is built on the first query", func(t *testing.T) {
+		data := []Entity{{1}, {2}}
+		calls := 0
+		index := NewBTree(&data, func(e *Entity) int {
+			calls++
+			return e.Key
+		}, WithLazyBuild())
+		assert.Equal(t, 0, calls)
+		assert.Equal(t, uint64(0), index.LockStats().Rebuild.Count)
+
+		data = append(data, Entity{1})
+		index.Put(&data[2], 2)
+		assert.Equal(t, 0, calls)
+
+		assert.Equal(t, []int{0, 2}, index.Get(1))
+		assert.Equal(t, []int{1}, index.Get(2))
+		assert.Equal(t, uint64(1), index.LockStats().Rebuild.Count)
+
+		data = append(data, Entity{2})
+		index.Put(&data[3], 3)
+		assert.Equal(t, []int{1, 3}, index.Find(2, EQ))
+	})
+
+	t.Run("concurrent first queries build once", func(t *testing.T) {
+		data := []Entity{{1}, {2}}
+		index := NewBTree(&data, field, WithLazyBuild())
+		var wg sync.WaitGroup
+		for j := 0; j < 8; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				assert.Equal(t, []int{0}, index.Get(1))
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, uint64(1), index.LockStats().Rebuild.Count)
+	})
+
+	t.Run("sorted index", func(t *testing.T) {
+		data := []Entity{{1}, {2}}
+		index := NewSortedIndex(&data, field, WithLazyBuild())
+		assert.Equal(t, uint64(0), index.LockStats().Rebuild.Count)
+		data = append(data, Entity{3})
+		assert.Equal(t, []int{1, 2}, index.Find(1, GT))
+		assert.Equal(t, uint64(1), index.LockStats().Rebuild.Count)
+	})
+
+	t.Run("disable for bulk load", func(t *testing.T) {
+		for _, mode := range []LockMode{RWLock, SingleWriter} {
+			data := []Entity{{1}, {2}}
+			index := NewBTree(&data, field, WithLocking(mode))
+			index.Disable()
+			assert.Equal(t, 0, index.Stats().Keys)
+			for j := 0; j < 100; j++ {
+				data = append(data, Entity{j % 5})
+				index.Put(&data[len(data)-1], len(data)-1)
+			}
+			assert.Equal(t, 0, index.Stats().Keys)
+			index.Enable()
+			assert.Equal(t, 5, index.Stats().Keys)
+			assert.Len(t, index.Get(1), 21)
+			index.Close()
+		}
+	})
+
+	t.Run("disabled index is built by query", func(t *testing.T) {
+		data := []Entity{{1}, {2}}
+		index := NewBTree(&data, field, WithBloomFilter(0.01))
+		index.Disable()
+		data = append(data, Entity{3})
+		assert.Equal(t, []int{2}, index.Get(3))
+	})
+	t.Run("mutations that waited for the build", func(t *testing.T) {
+		build := func(newIndex func(data *[]Entity, field func(e *Entity) int) lookup) {
+			data := []Entity{{1}, {2}, {3}, {4}}
+			var (
+				paused = make(chan struct{})
+				resume = make(chan struct{})
+				once   sync.Once
+			)
+			index := newIndex(&data, func(e *Entity) int {
+				if e.Key == 4 {
+					once.Do(func() {
+						close(paused)
+						<-resume
+					})
+				}
+				return e.Key
+			})
+			built := make(chan []int)
+			go func() {
+				built <- index.Get(1)
+			}()
+			<-paused
+
+			// the build has read all items, the mutations wait for its end
+			waits := hookWaits(t)
+			var wg sync.WaitGroup
+			wg.Add(3)
+			go func() {
+				defer wg.Done()
+				index.Put(&data[3], 3)
+			}()
+			go func() {
+				defer wg.Done()
+				assert.True(t, index.Reposition(&data[2], 2, 2))
+			}()
+			go func() {
+				defer wg.Done()
+				assert.NoError(t, index.Apply([]Op[Entity]{{Kind: OpPut, Item: &data[1], Pos: 1}}))
+			}()
+			for j := 0; j < 3; j++ {
+				<-waits
+			}
+			close(resume)
+			wg.Wait()
+
+			assert.Equal(t, []int{0}, <-built)
+			assert.Equal(t, []int{1}, index.Get(2))
+			assert.Equal(t, []int{2}, index.Get(3))
+			assert.Equal(t, []int{3}, index.Get(4))
+		}
+		build(func(data *[]Entity, field func(e *Entity) int) lookup {
+			return NewBTree(data, field, WithLazyBuild())
+		})
+		build(func(data *[]Entity, field func(e *Entity) int) lookup {
+			return NewHashIndex(data, field, WithLazyBuild())
+		})
+	})
+
+	t.Run("mutations during the rebuild of an index that isn't built", func(t *testing.T) {
+		for name, newIndex := range map[string]func(data *[]Entity, field func(e *Entity) int) lookup{
+			"lazy btree": func(data *[]Entity, field func(e *Entity) int) lookup {
+				return NewBTree(data, field, WithLazyBuild())
+			},
+			"disabled btree": func(data *[]Entity, field func(e *Entity) int) lookup {
+				index := NewBTree(data, field)
+				index.Disable()
+				return index
+			},
+			"lazy hash": func(data *[]Entity, field func(e *Entity) int) lookup {
+				return NewHashIndex(data, field, WithLazyBuild())
+			},
+		} {
+			data := []Entity{{1}, {2}, {3}, {4}}
+			var (
+				pause  atomic.Bool
+				paused = make(chan struct{})
+				resume = make(chan struct{})
+			)
+			index := newIndex(&data, func(e *Entity) int {
+				if e.Key == 3 && pause.CompareAndSwap(true, false) {
+					close(paused)
+					<-resume
+				}
+				return e.Key
+			})
+			pause.Store(true)
+			rebuilt := make(chan struct{})
+			go func() {
+				defer close(rebuilt)
+				index.Rebuild()
+			}()
+			<-paused
+
+			// the item is not in the data array the build reads
+			waits := hookWaits(t)
+			extra := Entity{5000}
+			mutated := make(chan struct{})
+			go func() {
+				defer close(mutated)
+				index.Put(&extra, 4)
+			}()
+			<-waits
+			close(resume)
+			<-rebuilt
+			<-mutated
+			assert.Equal(t, []int{4}, index.Get(5000), name)
+			assert.Equal(t, []int{2}, index.Get(3), name)
+		}
+	})
+}
+
+// hookWaits replaces testHookWait until the end of the test.
+// The returned channel receives a value for every mutation that waits for a build
+func hookWaits(t *testing.T) <-chan struct{} {
+	waits := make(chan struct{}, 16)
+	testHookWait = func() {
+		select {
+		case waits <- struct{}{}:
+		default:
+		}
+	}
+	t.Cleanup(func() {
+		testHookWait = func() {}
+	})
+	return waits
+}
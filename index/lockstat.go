@@ -0,0 +1,174 @@
+package index
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// lockOp is an index operation that takes the index lock
+type lockOp uint8
+
+const (
+	opGet lockOp = iota
+	opPut
+	opRm
+	opReposition
+	opApply
+	opFind
+	opGetRange
+	opRebuild
+	opStats
+	opCount
+)
+
+var lockOpNames = [opCount]string{"Get", "Put", "Rm", "Reposition", "Apply", "Find", "GetRange", "Rebuild", "Stats"}
+
+// LockStat is a lock usage summary of one operation type
+type LockStat struct {
+	// Count is the number of times the lock was taken
+	Count uint64
+	// Wait is the total time spent waiting for the lock
+	Wait time.Duration
+	// MaxWait is the longest single wait for the lock
+	MaxWait time.Duration
+	// Hold is the total time the lock was held
+	Hold time.Duration
+	// MaxHold is the longest single hold of the lock
+	MaxHold time.Duration
+}
+
+// LockStats is a lock usage summary of the index by operation type
+type LockStats struct {
+	Get        LockStat
+	Put        LockStat
+	Rm         LockStat
+	Reposition LockStat
+	Apply      LockStat
+	Find       LockStat
+	GetRange   LockStat
+	Rebuild    LockStat
+	Stats      LockStat
+}
+
+type lockCounter struct {
+	count   atomic.Uint64
+	wait    atomic.Int64
+	maxWait atomic.Int64
+	hold    atomic.Int64
+	maxHold atomic.Int64
+}
+
+func (c *lockCounter) stat() LockStat {
+	return LockStat{
+		Count:   c.count.Load(),
+		Wait:    time.Duration(c.wait.Load()),
+		MaxWait: time.Duration(c.maxWait.Load()),
+		Hold:    time.Duration(c.hold.Load()),
+		MaxHold: time.Duration(c.maxHold.Load()),
+	}
+}
+
+func (c *lockCounter) add(wait, hold time.Duration) {
+	c.count.Add(1)
+	c.wait.Add(int64(wait))
+	c.hold.Add(int64(hold))
+	storeMax(&c.maxWait, int64(wait))
+	storeMax(&c.maxHold, int64(hold))
+}
+
+func storeMax(v *atomic.Int64, val int64) {
+	for {
+		old := v.Load()
+		if val <= old || v.CompareAndSwap(old, val) {
+			return
+		}
+	}
+}
+
+// lockWatch is a callback for the write locks held longer than threshold
+type lockWatch struct {
+	threshold time.Duration
+	fn        func(op string, held time.Duration)
+}
+
+// locker is the index RWMutex that measures the time spent waiting for and holding it
+type locker struct {
+	rw       sync.RWMutex
+	ops      [opCount]lockCounter
+	watch    atomic.Pointer[lockWatch]
+	disabled bool
+}
+
+func noop() {}
+
+// lock takes the write lock for op and returns the function that releases it
+func (l *locker) lock(op lockOp) (unlock func()) {
+	if l.disabled {
+		return noop
+	}
+	start := time.Now()
+	l.rw.Lock()
+	locked := time.Now()
+	return func() {
+		l.rw.Unlock()
+		held := time.Since(locked)
+		l.ops[op].add(locked.Sub(start), held)
+		if w := l.watch.Load(); w != nil && held > w.threshold {
+			w.fn(lockOpNames[op], held)
+		}
+	}
+}
+
+// untrackedLock takes the write lock for a short bookkeeping change
+// that is not counted in the statistics and returns the function that releases it
+func (l *locker) untrackedLock() (unlock func()) {
+	if l.disabled {
+		return noop
+	}
+	l.rw.Lock()
+	return l.rw.Unlock
+}
+
+// rlock takes the read lock for op and returns the function that releases it
+func (l *locker) rlock(op lockOp) (unlock func()) {
+	if l.disabled {
+		return noop
+	}
+	start := time.Now()
+	l.rw.RLock()
+	locked := time.Now()
+	return func() {
+		l.rw.RUnlock()
+		l.ops[op].add(locked.Sub(start), time.Since(locked))
+	}
+}
+
+// LockStats returns the lock usage summary of the index
+func (l *locker) LockStats() LockStats {
+	return LockStats{
+		Get:        l.ops[opGet].stat(),
+		Put:        l.ops[opPut].stat(),
+		Rm:         l.ops[opRm].stat(),
+		Reposition: l.ops[opReposition].stat(),
+		Apply:      l.ops[opApply].stat(),
+		Find:       l.ops[opFind].stat(),
+		GetRange:   l.ops[opGetRange].stat(),
+		Rebuild:    l.ops[opRebuild].stat(),
+		Stats:      l.ops[opStats].stat(),
+	}
+}
+
+// OnSlowWrite sets fn to be called every time a writer holds the index lock longer than threshold.
+// fn is called after the lock is released with the operation name and the hold time.
+// A nil fn removes the callback
+func (l *locker) OnSlowWrite(threshold time.Duration, fn func(op string, held time.Duration)) {
+	if fn == nil {
+		l.watch.Store(nil)
+		return
+	}
+	l.watch.Store(&lockWatch{
+		threshold: threshold,
+		fn:        fn,
+	})
+}
@@ -0,0 +1,57 @@
+package index
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLockStats(t *testing.T) {
+	type Entity struct {
+		Key int
+	}
+	data := []Entity{{1}, {2}, {2}, {3}}
+	index := NewBTree(&data, func(e *Entity) int {
+		return e.Key
+	})
+
+	t.Run("count operations", func(t *testing.T) {
+		index.Get(2)
+		index.Get(3)
+		index.Find(2, GT)
+		index.GetRange(1, 3, true, true)
+		stats := index.LockStats()
+		assert.Equal(t, uint64(1), stats.Rebuild.Count)
+		assert.Equal(t, uint64(2), stats.Get.Count)
+		assert.Equal(t, uint64(1), stats.Find.Count)
+		assert.Equal(t, uint64(1), stats.GetRange.Count)
+		assert.Equal(t, uint64(0), stats.Put.Count)
+		assert.GreaterOrEqual(t, stats.Get.Hold, stats.Get.MaxHold)
+	})
+
+	t.Run("slow write callback", func(t *testing.T) {
+		var ops []string
+		index.OnSlowWrite(0, func(op string, held time.Duration) {
+			ops = append(ops, op)
+		})
+		index.Rebuild()
+		index.Get(1)
+		data = append(data, Entity{4})
+		index.Put(&data[len(data)-1], len(data)-1)
+		assert.Equal(t, []string{"Rebuild", "Put"}, ops)
+
+		index.OnSlowWrite(0, nil)
+		index.Rebuild()
+		assert.Equal(t, []string{"Rebuild", "Put"}, ops)
+	})
+
+	t.Run("threshold", func(t *testing.T) {
+		var called bool
+		index.OnSlowWrite(time.Hour, func(op string, held time.Duration) {
+			called = true
+		})
+		index.Rebuild()
+		assert.False(t, called)
+	})
+}
@@ -0,0 +1,188 @@
+package index
+
+import "fmt"
+
+// LockMode defines how the index protects itself from concurrent access
+type LockMode uint8
+
+const (
+	// RWLock guards the index by a RWMutex. It is the default mode
+	RWLock LockMode = iota
+	// NoLock disables the index lock.
+	// Use it when the index is accessed from one goroutine or synchronized by the caller.
+	// Lock statistics are not collected in this mode
+	NoLock
+	// SingleWriter queues the mutations to a dedicated goroutine that applies them
+	// and publishes a read-only copy of the index once the queue is empty.
+	// Reads never wait for the writer but can miss the latest queued mutations,
+	// Sync waits for them to be published. Close stops the goroutine.
+	// Lock statistics are not collected in this mode
+	SingleWriter
+)
+
+// defaultDegree is the degree of the balanced tree used when WithDegree is not set
+const defaultDegree = 4
+
+// Option configures an index
+type Option func(o *options)
+
+type options struct {
+	degree        int
+	lockMode      LockMode
+	sorted        bool
+	predicate     any
+	bloomRate     float64
+	packThreshold int
+	lazy          bool
+	pace          int
+	progress      func(p Progress)
+	stripes       int
+	postingOrder  any
+}
+
+func newOptions(opts []Option) options {
+	o := options{
+		degree: defaultDegree,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// predicateFor returns the predicate set by WithPredicate for the data array of A
+func predicateFor[A any](o options) func(item *A) bool {
+	if o.predicate == nil {
+		return nil
+	}
+	fn, ok := o.predicate.(func(item *A) bool)
+	if !ok {
+		var item A
+		panic(fmt.Errorf("index: predicate %T does not match the data array of %T", o.predicate, item))
+	}
+	return fn
+}
+
+// postingOrderFor returns the comparison set by WithPostingOrder for the data array of A
+func postingOrderFor[A any](o options) func(x, y *A) bool {
+	if o.postingOrder == nil {
+		return nil
+	}
+	fn, ok := o.postingOrder.(func(x, y *A) bool)
+	if !ok {
+		var item A
+		panic(fmt.Errorf("index: posting order %T does not match the data array of %T", o.postingOrder, item))
+	}
+	return fn
+}
+
+// WithDegree sets the degree of the balanced tree
+func WithDegree(degree int) Option {
+	return func(o *options) {
+		if degree < 2 {
+			panic(fmt.Errorf("index: invalid degree %d", degree))
+		}
+		o.degree = degree
+	}
+}
+
+// WithLocking sets how the index protects itself from concurrent access
+func WithLocking(mode LockMode) Option {
+	return func(o *options) {
+		o.lockMode = mode
+	}
+}
+
+// WithSortedResults makes the index return data array indexes in ascending order.
+// The returned slices are copies and can be modified by the caller
+func WithSortedResults() Option {
+	return func(o *options) {
+		o.sorted = true
+	}
+}
+
+// WithPredicate makes a partial index: only the items for which fn returns true are indexed
+func WithPredicate[A any](fn func(item *A) bool) Option {
+	return func(o *options) {
+		o.predicate = fn
+	}
+}
+
+// WithBloomFilter puts a bloom filter in front of the index,
+// so lookups of missing keys return without taking the index lock.
+// The filter is sized at Rebuild for twice the number of keys with the false positive rate,
+// keys added by Put after that increase the rate until the next Rebuild
+func WithBloomFilter(falsePositiveRate float64) Option {
+	return func(o *options) {
+		if falsePositiveRate <= 0 || falsePositiveRate >= 1 {
+			panic(fmt.Errorf("index: invalid false positive rate %v", falsePositiveRate))
+		}
+		o.bloomRate = falsePositiveRate
+	}
+}
+
+// WithPackedPostings keeps the data array indexes of every key sorted
+// and stores the lists longer than threshold delta-encoded in blocks.
+// Packed lists use several times less memory and are checked by Contains with binary search,
+// Get decodes them into a new slice.
+// Put and Rm copy the changed list or block, so they are slower for the large lists
+func WithPackedPostings(threshold int) Option {
+	return func(o *options) {
+		if threshold < 1 {
+			panic(fmt.Errorf("index: invalid posting list threshold %d", threshold))
+		}
+		o.packThreshold = threshold
+	}
+}
+
+// WithLazyBuild postpones the index build from the constructor to the first query.
+// Mutations before the first query are skipped, the build reads the data array with them.
+// It has no effect in the SingleWriter mode
+func WithLazyBuild() Option {
+	return func(o *options) {
+		o.lazy = true
+	}
+}
+
+// WithRebuildPacing limits Rebuild to rowsPerSecond data array items.
+// Rebuild yields the processor between the chunks of items and sleeps to keep the pace,
+// so a rebuild of a large index on a live node doesn't take the CPU from the readers
+func WithRebuildPacing(rowsPerSecond int) Option {
+	return func(o *options) {
+		if rowsPerSecond < 1 {
+			panic(fmt.Errorf("index: invalid rebuild pace %d", rowsPerSecond))
+		}
+		o.pace = rowsPerSecond
+	}
+}
+
+// WithRebuildProgress sets fn to be called by Rebuild after every chunk of processed data array items
+// and at the end of the build. fn is called from the goroutine running Rebuild without the index lock
+func WithRebuildProgress(fn func(p Progress)) Option {
+	return func(o *options) {
+		o.progress = fn
+	}
+}
+
+// WithLockStripes sets the number of the lock stripes of HashIndex.
+// More stripes make the writers of different keys contend less and cost more for Apply and Rebuild
+func WithLockStripes(n int) Option {
+	return func(o *options) {
+		if n < 1 {
+			panic(fmt.Errorf("index: invalid number of lock stripes %d", n))
+		}
+		o.stripes = n
+	}
+}
+
+// WithPostingOrder keeps the data array indexes of every key ordered by less of their items,
+// e.g. by timestamp, so Get returns them in this order without a sort.
+// Items with equal order stay in the order they were put.
+// The items are read from the data array, so a field used by less must be changed
+// by Rm and Put of the item. Mutations wait for a running Rebuild instead of being journaled.
+// It can't be combined with WithSortedResults, WithPackedPostings and the SingleWriter mode
+func WithPostingOrder[A any](less func(x, y *A) bool) Option {
+	return func(o *options) {
+		o.postingOrder = less
+	}
+}
@@ -0,0 +1,147 @@
+package index
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOptions(t *testing.T) {
+	type Entity struct {
+		Key    int
+		Active bool
+	}
+	init := func() *[]Entity {
+		return &[]Entity{
+			{5, true},
+			{1, true},
+			{5, false},
+			{3, true},
+			{5, true},
+			{1, false},
+		}
+	}
+	field := func(e *Entity) int {
+		return e.Key
+	}
+
+	t.Run("degree", func(t *testing.T) {
+		index := NewBTree(init(), field, WithDegree(32))
+		assert.Equal(t, 3, index.Stats().Keys)
+		assert.Panics(t, func() {
+			NewBTree(init(), field, WithDegree(1))
+		})
+	})
+
+	t.Run("sorted results", func(t *testing.T) {
+		data := init()
+		index := NewBTree(data, field, WithSortedResults())
+		*data = append(*data, Entity{5, true})
+		index.Put(&(*data)[6], 6)
+		index.Rm(&(*data)[0], 0)
+		*data = append(*data, Entity{5, true})
+		index.Put(&(*data)[7], 7)
+		index.Put(&(*data)[0], 0)
+		assert.Equal(t, []int{0, 2, 4, 6, 7}, index.Get(5))
+		assert.Equal(t, []int{0, 2, 3, 4, 6, 7}, index.Find(1, GT))
+		assert.Equal(t, []int{0, 1, 2, 3, 4, 5, 6, 7}, index.Find(5, LTE))
+
+		// returned slices are copies
+		actual := index.Get(5)
+		actual[0] = 100
+		assert.Equal(t, []int{0, 2, 4, 6, 7}, index.Get(5))
+	})
+
+	t.Run("predicate", func(t *testing.T) {
+		data := init()
+		index := NewBTree(data, field, WithPredicate(func(e *Entity) bool {
+			return e.Active
+		}), WithSortedResults())
+		assert.Equal(t, []int{0, 4}, index.Get(5))
+		assert.Equal(t, []int{1}, index.Get(1))
+
+		*data = append(*data, Entity{3, false}, Entity{3, true})
+		index.Put(&(*data)[6], 6)
+		index.Put(&(*data)[7], 7)
+		assert.Equal(t, []int{3, 7}, index.Get(3))
+
+		index.Rm(&(*data)[2], 2)
+		assert.Equal(t, []int{0, 4}, index.Get(5))
+	})
+
+	t.Run("predicate of another type", func(t *testing.T) {
+		assert.Panics(t, func() {
+			NewBTree(init(), field, WithPredicate(func(s *string) bool {
+				return true
+			}))
+		})
+	})
+
+	t.Run("no lock", func(t *testing.T) {
+		index := NewBTree(init(), field, WithLocking(NoLock))
+		assert.Len(t, index.Get(5), 3)
+		assert.Equal(t, LockStats{}, index.LockStats())
+	})
+}
+
+func TestPostingOrder(t *testing.T) {
+	type Event struct {
+		Entity int
+		Time   int
+	}
+	data := []Event{
+		{1, 30},
+		{2, 10},
+		{1, 10},
+		{1, 20},
+		{1, 20},
+	}
+	newest := func(x, y *Event) bool {
+		return x.Time > y.Time
+	}
+	index := NewBTree(&data, func(e *Event) int {
+		return e.Entity
+	}, WithPostingOrder(newest))
+	assert.Equal(t, []int{0, 3, 4, 2}, index.Get(1))
+
+	data = append(data, Event{1, 25}, Event{1, 5})
+	index.Put(&data[5], 5)
+	index.Put(&data[6], 6)
+	assert.Equal(t, []int{0, 5, 3, 4, 2, 6}, index.Get(1))
+
+	// swap-delete keeps the order
+	index.Rm(&data[3], 3)
+	assert.True(t, index.Reposition(&data[6], 6, 3))
+	data[3], data = data[6], data[:6]
+	assert.Equal(t, []int{0, 5, 4, 2, 3}, index.Get(1))
+	assert.Equal(t, []int{1, 0, 5, 4, 2, 3}, index.Find(2, LTE))
+
+	// the put item is compared, not the data array item at its position
+	extra := Event{1, 15}
+	index.Put(&extra, 9)
+	assert.Equal(t, []int{0, 5, 4, 9, 2, 3}, index.Get(1))
+	index.Rm(&extra, 9)
+
+	index.Rebuild()
+	assert.Equal(t, []int{0, 5, 4, 2, 3}, index.Get(1))
+
+	t.Run("invalid combinations", func(t *testing.T) {
+		assert.Panics(t, func() {
+			NewBTree(&data, func(e *Event) int {
+				return e.Entity
+			}, WithPostingOrder(newest), WithSortedResults())
+		})
+		assert.Panics(t, func() {
+			NewBTree(&data, func(e *Event) int {
+				return e.Entity
+			}, WithPostingOrder(newest), WithLocking(SingleWriter))
+		})
+		assert.Panics(t, func() {
+			NewBTree(&data, func(e *Event) int {
+				return e.Entity
+			}, WithPostingOrder(func(x, y *string) bool {
+				return false
+			}))
+		})
+	})
+}
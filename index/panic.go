@@ -0,0 +1,35 @@
+package index
+
+import (
+	"fmt"
+	"runtime/debug"
+
+	"github.com/nikk-gr/strmem/errs"
+)
+
+// PanicError is returned when a user-provided function panics inside the index,
+// e.g. the field extractor during Rebuild. It wraps errs.ErrPanic
+type PanicError struct {
+	// Value is the value passed to panic
+	Value any
+	// Stack is the stack trace of the panicking goroutine
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("index: %v: %v", errs.ErrPanic, e.Value)
+}
+
+func (e *PanicError) Unwrap() error {
+	return errs.ErrPanic
+}
+
+// recoverTo recovers the panic of the deferring function into err
+func recoverTo(err *error) {
+	if r := recover(); r != nil {
+		*err = &PanicError{
+			Value: r,
+			Stack: debug.Stack(),
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package index
+
+import (
+	"encoding/binary"
+	"sort"
+	"unsafe"
+)
+
+// packedBlockSize is the number of data array indexes in one block of packed postings
+const packedBlockSize = 128
+
+// packedBlock is a part of packed postings.
+// The first index is stored as is and the following ones as varint deltas
+type packedBlock struct {
+	first  int
+	count  int
+	deltas []byte
+}
+
+// packedPostings is a sorted set of data array indexes split into delta-encoded blocks.
+// Membership is checked by binary search over the first indexes of the blocks
+// and decoding of one block. packedPostings is immutable, changes return a new value
+// that shares the unchanged blocks
+type packedPostings struct {
+	blocks []packedBlock
+	count  int
+}
+
+// packPostings makes packed postings from the sorted data array indexes
+func packPostings(arr []int) *packedPostings {
+	p := packedPostings{
+		blocks: make([]packedBlock, 0, (len(arr)+packedBlockSize-1)/packedBlockSize),
+		count:  len(arr),
+	}
+	for start := 0; start < len(arr); start += packedBlockSize {
+		end := start + packedBlockSize
+		if end > len(arr) {
+			end = len(arr)
+		}
+		p.blocks = append(p.blocks, packBlock(arr[start:end]))
+	}
+	return &p
+}
+
+func packBlock(arr []int) packedBlock {
+	b := packedBlock{
+		first: arr[0],
+		count: len(arr),
+	}
+	buf := make([]byte, 0, len(arr))
+	for j := 1; j < len(arr); j++ {
+		buf = binary.AppendUvarint(buf, uint64(arr[j]-arr[j-1]))
+	}
+	b.deltas = buf
+	return b
+}
+
+// appendTo decodes the block and appends its data array indexes to arr
+func (b packedBlock) appendTo(arr []int) []int {
+	val := b.first
+	arr = append(arr, val)
+	for buf := b.deltas; len(buf) > 0; {
+		delta, n := binary.Uvarint(buf)
+		buf = buf[n:]
+		val += int(delta)
+		arr = append(arr, val)
+	}
+	return arr
+}
+
+// unpack returns all data array indexes in ascending order
+func (p *packedPostings) unpack() []int {
+	arr := make([]int, 0, p.count)
+	for _, b := range p.blocks {
+		arr = b.appendTo(arr)
+	}
+	return arr
+}
+
+// block returns the number of the block that can contain val
+func (p *packedPostings) block(val int) int {
+	j := sort.Search(len(p.blocks), func(j int) bool {
+		return p.blocks[j].first > val
+	})
+	if j > 0 {
+		j--
+	}
+	return j
+}
+
+// contains reports whether val is in the postings
+func (p *packedPostings) contains(val int) bool {
+	if len(p.blocks) == 0 {
+		return false
+	}
+	arr := p.blocks[p.block(val)].appendTo(make([]int, 0, packedBlockSize))
+	j := sort.SearchInts(arr, val)
+	return j < len(arr) && arr[j] == val
+}
+
+// insert returns the postings with val added
+func (p *packedPostings) insert(val int) *packedPostings {
+	if len(p.blocks) == 0 {
+		return packPostings([]int{val})
+	}
+	j := p.block(val)
+	arr := sortedInsert(p.blocks[j].appendTo(make([]int, 0, packedBlockSize+1)), val)
+	var blocks []packedBlock
+	if len(arr) > 2*packedBlockSize {
+		// the block is split in two
+		half := len(arr) / 2
+		blocks = make([]packedBlock, 0, len(p.blocks)+1)
+		blocks = append(blocks, p.blocks[:j]...)
+		blocks = append(blocks, packBlock(arr[:half]), packBlock(arr[half:]))
+		blocks = append(blocks, p.blocks[j+1:]...)
+	} else {
+		blocks = append([]packedBlock(nil), p.blocks...)
+		blocks[j] = packBlock(arr)
+	}
+	return &packedPostings{
+		blocks: blocks,
+		count:  p.count + 1,
+	}
+}
+
+// remove returns the postings without val and reports whether val was found
+func (p *packedPostings) remove(val int) (*packedPostings, bool) {
+	if len(p.blocks) == 0 {
+		return p, false
+	}
+	j := p.block(val)
+	arr, ok := sortedRemove(p.blocks[j].appendTo(make([]int, 0, packedBlockSize)), val)
+	if !ok {
+		return p, false
+	}
+	var blocks []packedBlock
+	if len(arr) == 0 {
+		blocks = make([]packedBlock, 0, len(p.blocks)-1)
+		blocks = append(blocks, p.blocks[:j]...)
+		blocks = append(blocks, p.blocks[j+1:]...)
+	} else {
+		blocks = append([]packedBlock(nil), p.blocks...)
+		blocks[j] = packBlock(arr)
+	}
+	return &packedPostings{
+		blocks: blocks,
+		count:  p.count - 1,
+	}, true
+}
+
+// bytes returns the memory used by the postings
+func (p *packedPostings) bytes() uintptr {
+	size := unsafe.Sizeof(*p) + uintptr(cap(p.blocks))*unsafe.Sizeof(packedBlock{})
+	for _, b := range p.blocks {
+		size += uintptr(cap(b.deltas))
+	}
+	return size
+}
+
+// sortedInsert returns a copy of the sorted arr with val inserted
+func sortedInsert(arr []int, val int) []int {
+	j := sort.SearchInts(arr, val)
+	res := make([]int, len(arr)+1)
+	copy(res, arr[:j])
+	res[j] = val
+	copy(res[j+1:], arr[j:])
+	return res
+}
+
+// sortedRemove returns a copy of the sorted arr without val and reports whether val was found
+func sortedRemove(arr []int, val int) ([]int, bool) {
+	j := sort.SearchInts(arr, val)
+	if j == len(arr) || arr[j] != val {
+		return arr, false
+	}
+	res := make([]int, 0, len(arr)-1)
+	res = append(res, arr[:j]...)
+	return append(res, arr[j+1:]...), true
+}
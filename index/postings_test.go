@@ -0,0 +1,115 @@
+package index
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPackedPostings(t *testing.T) {
+	arr := make([]int, 1000)
+	for j := range arr {
+		arr[j] = j * 3
+	}
+
+	t.Run("pack and unpack", func(t *testing.T) {
+		p := packPostings(arr)
+		assert.Equal(t, arr, p.unpack())
+		assert.Len(t, p.blocks, 8)
+		assert.True(t, p.bytes() < uintptr(len(arr))*postingSize)
+	})
+
+	t.Run("contains", func(t *testing.T) {
+		p := packPostings(arr)
+		assert.True(t, p.contains(0))
+		assert.True(t, p.contains(384))
+		assert.True(t, p.contains(2997))
+		assert.False(t, p.contains(385))
+		assert.False(t, p.contains(-1))
+		assert.False(t, p.contains(3000))
+	})
+
+	t.Run("insert and remove", func(t *testing.T) {
+		p := packPostings(arr)
+		expectation := append([]int(nil), arr...)
+		for j := 0; j < 500; j++ {
+			val := rand.Intn(4000) - 100
+			p = p.insert(val)
+			expectation = sortedInsert(expectation, val)
+		}
+		assert.Equal(t, expectation, p.unpack())
+		assert.Equal(t, len(expectation), p.count)
+
+		old := p
+		for _, val := range arr {
+			var ok bool
+			p, ok = p.remove(val)
+			assert.True(t, ok)
+			expectation, _ = sortedRemove(expectation, val)
+		}
+		_, ok := p.remove(-1000)
+		assert.False(t, ok)
+		assert.Equal(t, expectation, p.unpack())
+		assert.Equal(t, 1500, old.count, "packed postings were changed in place")
+	})
+}
+
+func TestSortedInsertRemove(t *testing.T) {
+	arr := []int{1, 3, 5}
+	assert.Equal(t, []int{0, 1, 3, 5}, sortedInsert(arr, 0))
+	assert.Equal(t, []int{1, 3, 4, 5}, sortedInsert(arr, 4))
+	assert.Equal(t, []int{1, 3, 5, 6}, sortedInsert(arr, 6))
+	res, ok := sortedRemove(arr, 3)
+	assert.True(t, ok)
+	assert.Equal(t, []int{1, 5}, res)
+	_, ok = sortedRemove(arr, 2)
+	assert.False(t, ok)
+	assert.Equal(t, []int{1, 3, 5}, arr)
+}
+
+func TestBtreePackedPostings(t *testing.T) {
+	type Entity struct {
+		Key int
+	}
+	data := make([]Entity, 3000)
+	for j := range data {
+		data[j].Key = j % 3
+	}
+	index := NewBTree(&data, func(e *Entity) int {
+		return e.Key
+	}, WithPackedPostings(64))
+	expectation := map[int][]int{}
+	for j := range data {
+		expectation[j%3] = append(expectation[j%3], j)
+	}
+
+	assert.Equal(t, expectation[1], index.Get(1))
+	assert.True(t, index.Contains(2, 2999))
+	assert.False(t, index.Contains(2, 2998))
+	assert.True(t, index.Stats().Bytes < uintptr(len(data))*postingSize)
+
+	// swap-delete of random elements
+	for n := 0; n < 2000; n++ {
+		pos := rand.Intn(len(data))
+		last := len(data) - 1
+		key, lastKey := data[pos].Key, data[last].Key
+		index.Rm(&data[pos], pos)
+		expectation[key], _ = sortedRemove(expectation[key], pos)
+		if pos != last {
+			assert.True(t, index.Reposition(&data[last], last, pos))
+			expectation[lastKey], _ = sortedRemove(expectation[lastKey], last)
+			expectation[lastKey] = sortedInsert(expectation[lastKey], pos)
+			data[pos] = data[last]
+		}
+		data = data[:last]
+	}
+	for key := 0; key < 3; key++ {
+		assert.Equal(t, expectation[key], index.Get(key))
+	}
+	actual := index.Find(0, GT)
+	sort.Ints(actual)
+	assert.Equal(t, len(expectation[1])+len(expectation[2]), len(actual))
+	assert.Equal(t, 1000, index.Stats().Postings)
+}
@@ -0,0 +1,357 @@
+package index
+
+import (
+	"context"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/btree"
+)
+
+// pacingChunk is the number of data array items Rebuild processes between the pauses
+const pacingChunk = 1024
+
+// rebuildState is the state of the running Rebuild
+type rebuildState[T btree.Ordered] struct {
+	// gate lets one Rebuild run at a time
+	gate  sync.Mutex
+	done  atomic.Int64
+	total atomic.Int64
+	// swaps counts the new trees that replaced the old ones
+	swaps atomic.Int64
+	// journaling and journal are guarded by the index lock
+	journaling bool
+	journal    []journalEntry[T]
+}
+
+// journalEntry is a mutation made while Rebuild builds the new tree outside of the index lock
+type journalEntry[T btree.Ordered] struct {
+	kind   OpKind
+	key    T
+	pos    int
+	newPos int
+	// projection is the projection of the put or repositioned item, see Covering
+	projection any
+}
+
+// Progress is the progress of Rebuild
+type Progress struct {
+	// Done is the number of processed data array items
+	Done int
+	// Total is the number of data array items to be processed
+	Total int
+	// Elapsed is the time since the start of Rebuild
+	Elapsed time.Duration
+	// ETA is the estimated time to the end of Rebuild
+	ETA time.Duration
+}
+
+// Rebuild removes the old index and builds new.
+// The new tree is built without the index lock, so the readers use the old one until it is ready.
+// Mutations don't wait for Rebuild: they change the old tree and are journaled,
+// the journal is replayed onto the new tree before it replaces the old one.
+// The mutations of an index WithPostingOrder wait for Rebuild instead, so the replay never reads the data array.
+// If the field extractor panics, the old tree is kept and Rebuild panics with *PanicError
+// after the locks are released. In the SingleWriter mode such a rebuild is dropped
+func (i *BTree[T, A]) Rebuild() {
+	if i.writer != nil {
+		i.writer.enqueue(i.rebuild)
+		i.lazy.built.Store(true)
+		return
+	}
+	if err := i.RebuildContext(context.Background()); err != nil {
+		panic(err)
+	}
+}
+
+// RebuildContext is Rebuild that can be cancelled by ctx.
+// If ctx is done before the new tree is ready, the old tree is kept and ctx.Err() is returned.
+// If the field extractor panics, the old tree is kept and *PanicError is returned.
+// In the SingleWriter mode RebuildContext waits until the new tree is published
+func (i *BTree[T, A]) RebuildContext(ctx context.Context) error {
+	// the build of an index that isn't built yet holds the lock of the lazy build,
+	// so the mutations wait for it instead of being skipped as pending
+	i.lazy.mu.Lock()
+	defer i.lazy.mu.Unlock()
+	return i.rebuildContext(ctx)
+}
+
+// rebuildContext is RebuildContext for callers that hold the lock of the lazy build
+func (i *BTree[T, A]) rebuildContext(ctx context.Context) (err error) {
+	if i.writer != nil {
+		i.writer.await(func() {
+			var (
+				tree *btree.BTreeG[indexNode[T]]
+				b    *bloom
+			)
+			if tree, b, err = i.buildTree(ctx); err == nil {
+				i.tree = tree
+				i.bloom.Store(b)
+				i.lazy.built.Store(true)
+			}
+		})
+		return err
+	}
+	i.rebuilding.gate.Lock()
+	defer i.rebuilding.gate.Unlock()
+	unlock := i.untrackedLock()
+	i.rebuilding.journaling = true
+	unlock()
+
+	tree, b, err := i.buildTree(ctx)
+
+	defer i.lock(opRebuild)()
+	journal := i.rebuilding.journal
+	i.rebuilding.journaling, i.rebuilding.journal = false, nil
+	if err != nil {
+		return err
+	}
+	journal = compact(journal)
+	// Get checks the bloom filter without the lock, so it gets the journaled keys before it is published
+	addJournal(b, journal)
+	i.tree = tree
+	i.bloom.Store(b)
+	i.replay(journal)
+	i.rebuilding.swaps.Add(1)
+	i.lazy.built.Store(true)
+	return nil
+}
+
+// RebuildAsync runs RebuildContext in a new goroutine.
+// The returned channel receives its result and is closed
+func (i *BTree[T, A]) RebuildAsync(ctx context.Context) <-chan error {
+	res := make(chan error, 1)
+	go func() {
+		defer close(res)
+		res <- i.RebuildContext(ctx)
+	}()
+	return res
+}
+
+// rebuild is Rebuild for callers that already hold the lock
+func (i *BTree[T, A]) rebuild() {
+	tree, b, err := i.buildTree(context.Background())
+	if err != nil {
+		return
+	}
+	i.tree = tree
+	i.bloom.Store(b)
+}
+
+// RebuildProgress returns the number of data array items processed by the running or the last Rebuild
+// and the number of items to be processed
+func (i *BTree[T, A]) RebuildProgress() (done, total int) {
+	return int(i.rebuilding.done.Load()), int(i.rebuilding.total.Load())
+}
+
+// record journals the mutation if Rebuild is building the new tree, the lock must be held
+func (i *BTree[T, A]) record(kind OpKind, key T, pos, newPos int, projection any) {
+	if i.rebuilding.journaling {
+		i.rebuilding.journal = append(i.rebuilding.journal, journalEntry[T]{
+			kind:       kind,
+			key:        key,
+			pos:        pos,
+			newPos:     newPos,
+			projection: projection,
+		})
+	}
+}
+
+// replay applies the journaled mutations to the new tree, the lock must be held.
+// The build could already read some of them from the data array, so they are applied idempotently
+func (i *BTree[T, A]) replay(journal []journalEntry[T]) {
+	for _, e := range journal {
+		switch e.kind {
+		case OpPut:
+			if !i.contains(i.tree, e.key, e.pos) {
+				i.put(e.key, e.pos, nil, e.projection)
+			}
+		case OpRm:
+			i.rm(e.key, e.pos)
+		case OpReposition:
+			i.rm(e.key, e.pos)
+			if !i.contains(i.tree, e.key, e.newPos) {
+				i.put(e.key, e.newPos, nil, e.projection)
+			}
+		}
+	}
+}
+
+// compact drops the journaled puts removed by the later entries, the rest is kept in order.
+// The removals are kept: the build could read the removed item from the data array
+func compact[T btree.Ordered](journal []journalEntry[T]) []journalEntry[T] {
+	type posting struct {
+		key T
+		pos int
+	}
+	removed := map[posting]bool{}
+	res := make([]journalEntry[T], 0, len(journal))
+	for j := len(journal) - 1; j >= 0; j-- {
+		e := journal[j]
+		switch e.kind {
+		case OpPut:
+			if removed[posting{e.key, e.pos}] {
+				continue
+			}
+		case OpRm:
+			removed[posting{e.key, e.pos}] = true
+		case OpReposition:
+			if removed[posting{e.key, e.newPos}] {
+				// the moved item is removed later, so only the removal of the old position is left
+				e = journalEntry[T]{kind: OpRm, key: e.key, pos: e.pos}
+			}
+			removed[posting{e.key, e.pos}] = true
+		}
+		res = append(res, e)
+	}
+	for l, r := 0, len(res)-1; l < r; l, r = l+1, r-1 {
+		res[l], res[r] = res[r], res[l]
+	}
+	return res
+}
+
+// addJournal adds the keys put by the journal to the bloom filter, b can be nil
+func addJournal[T btree.Ordered](b *bloom, journal []journalEntry[T]) {
+	if b == nil {
+		return
+	}
+	for _, e := range journal {
+		if e.kind != OpRm {
+			b.add(hashKey(b.seed, e.key))
+		}
+	}
+}
+
+// buildTree builds the new tree and the bloom filter of the data array.
+// A panic of the user-provided functions is returned as *PanicError
+func (i *BTree[T, A]) buildTree(ctx context.Context) (_ *btree.BTreeG[indexNode[T]], _ *bloom, err error) {
+	defer recoverTo(&err)
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	tree := i.newTree()
+	// the writers could shrink the data array meanwhile, so the build reads the slice it started with
+	data := *i.dataPtr
+	total := len(data)
+	i.rebuilding.total.Store(int64(total))
+	i.rebuilding.done.Store(0)
+	start := time.Now()
+
+	var (
+		tmpINode indexNode[T]
+		ok       bool
+		tmpData  T
+	)
+	for j := range data {
+		if j%pacingChunk == 0 && j > 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, nil, err
+			}
+			i.rebuilding.done.Store(int64(j))
+			i.report(start, j, total)
+			i.pause(start, j)
+		}
+		if !i.accepts(&data[j]) {
+			continue
+		}
+		tmpData = i.getField(&data[j])
+		tmpINode, ok = tree.Get(indexNode[T]{
+			data: tmpData,
+		})
+		if !ok {
+			tmpINode = indexNode[T]{
+				data: tmpData,
+			}
+		}
+		tmpINode.index = append(tmpINode.index, j)
+		if i.project != nil {
+			tmpINode.projections = append(tmpINode.projections, i.project(&data[j]))
+		}
+		tree.ReplaceOrInsert(tmpINode)
+	}
+	i.rebuilding.done.Store(int64(total))
+	i.report(start, total, total)
+
+	if i.order != nil {
+		tree.Ascend(func(in indexNode[T]) bool {
+			// the postings are owned by the new tree, so they are sorted in place
+			sort.Stable(byOrder[T, A]{in, data, i.order})
+			return true
+		})
+	}
+
+	if i.packThreshold > 0 {
+		// the data array is read in order, so the postings are already sorted
+		var large []indexNode[T]
+		tree.Ascend(func(in indexNode[T]) bool {
+			if len(in.index) > i.packThreshold {
+				large = append(large, in)
+			}
+			return true
+		})
+		for _, in := range large {
+			in.packed, in.index = packPostings(in.index), nil
+			tree.ReplaceOrInsert(in)
+		}
+	}
+
+	if i.bloomRate == 0 {
+		return tree, nil, nil
+	}
+	b := newBloom(2*tree.Len(), i.bloomRate)
+	tree.Ascend(func(in indexNode[T]) bool {
+		b.add(hashKey(b.seed, in.data))
+		return true
+	})
+	return tree, b, nil
+}
+
+// byOrder sorts the postings of a node by the posting order, the projections stay next to them
+type byOrder[T btree.Ordered, A any] struct {
+	n    indexNode[T]
+	data []A
+	less func(x, y *A) bool
+}
+
+func (b byOrder[T, A]) Len() int { return len(b.n.index) }
+func (b byOrder[T, A]) Less(x, y int) bool {
+	return b.less(&b.data[b.n.index[x]], &b.data[b.n.index[y]])
+}
+func (b byOrder[T, A]) Swap(x, y int) {
+	b.n.index[x], b.n.index[y] = b.n.index[y], b.n.index[x]
+	if b.n.projections != nil {
+		b.n.projections[x], b.n.projections[y] = b.n.projections[y], b.n.projections[x]
+	}
+}
+
+// report calls the callback set by WithRebuildProgress
+func (i *BTree[T, A]) report(start time.Time, done, total int) {
+	if i.progress == nil {
+		return
+	}
+	p := Progress{
+		Done:    done,
+		Total:   total,
+		Elapsed: time.Since(start),
+	}
+	if done > 0 {
+		p.ETA = time.Duration(float64(p.Elapsed) / float64(done) * float64(total-done))
+	}
+	i.progress(p)
+}
+
+// pause yields the processor between the chunks of Rebuild
+// and sleeps to keep the pace set by WithRebuildPacing
+func (i *BTree[T, A]) pause(start time.Time, processed int) {
+	if i.pace <= 0 {
+		return
+	}
+	runtime.Gosched()
+	expected := time.Duration(float64(processed) / float64(i.pace) * float64(time.Second))
+	if elapsed := time.Since(start); elapsed < expected {
+		time.Sleep(expected - elapsed)
+	}
+}
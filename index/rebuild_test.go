@@ -0,0 +1,333 @@
+package index
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/nikk-gr/strmem/errs"
+)
+
+func TestRebuildPacing(t *testing.T) {
+	type Entity struct {
+		Key int
+	}
+	data := make([]Entity, 3000)
+	for j := range data {
+		data[j].Key = j % 10
+	}
+	index := NewBTree(&data, func(e *Entity) int {
+		return e.Key
+	}, WithRebuildPacing(10000))
+	done, total := index.RebuildProgress()
+	assert.Equal(t, 3000, done)
+	assert.Equal(t, 3000, total)
+
+	data[0].Key = 100
+	rebuilt := make(chan time.Duration)
+	go func() {
+		start := time.Now()
+		index.Rebuild()
+		rebuilt <- time.Since(start)
+	}()
+
+	// readers use the old tree while the new one is built
+	time.Sleep(50 * time.Millisecond)
+	start := time.Now()
+	assert.Nil(t, index.Get(100))
+	assert.Less(t, time.Since(start), 50*time.Millisecond)
+	done, _ = index.RebuildProgress()
+	assert.Less(t, done, 3000)
+
+	// writers don't wait for the end of the rebuild
+	start = time.Now()
+	extra := Entity{100}
+	index.Put(&extra, 3000)
+	index.Rm(&data[5], 5)
+	assert.Less(t, time.Since(start), 50*time.Millisecond)
+	assert.Equal(t, []int{3000}, index.Get(100))
+
+	// and their changes are replayed onto the new tree
+	assert.GreaterOrEqual(t, <-rebuilt, 200*time.Millisecond)
+	assert.Equal(t, []int{0, 3000}, index.Get(100))
+	assert.False(t, index.Contains(5, 5))
+	assert.True(t, index.Contains(5, 15))
+}
+
+func TestRebuildContext(t *testing.T) {
+	type Entity struct {
+		Key int
+	}
+	data := make([]Entity, 5000)
+	for j := range data {
+		data[j].Key = j % 10
+	}
+	var (
+		mu       sync.Mutex
+		progress []Progress
+	)
+	index := NewBTree(&data, func(e *Entity) int {
+		return e.Key
+	}, WithRebuildPacing(20000), WithRebuildProgress(func(p Progress) {
+		mu.Lock()
+		progress = append(progress, p)
+		mu.Unlock()
+	}))
+
+	t.Run("progress", func(t *testing.T) {
+		mu.Lock()
+		defer mu.Unlock()
+		assert.Len(t, progress, 5)
+		assert.Equal(t, 1024, progress[0].Done)
+		assert.Equal(t, 5000, progress[0].Total)
+		assert.True(t, progress[0].ETA > 0)
+		assert.Equal(t, Progress{Done: 5000, Total: 5000, Elapsed: progress[4].Elapsed}, progress[4])
+	})
+
+	t.Run("cancel", func(t *testing.T) {
+		data[0].Key = 100
+		ctx, cancel := context.WithTimeout(context.Background(), 60*time.Millisecond)
+		defer cancel()
+		assert.ErrorIs(t, <-index.RebuildAsync(ctx), context.DeadlineExceeded)
+		// the old tree is kept
+		assert.Nil(t, index.Get(100))
+		assert.Contains(t, index.Get(0), 0)
+		// the writers are released
+		extra := Entity{100}
+		index.Put(&extra, 5000)
+		assert.Equal(t, []int{5000}, index.Get(100))
+	})
+
+	t.Run("complete", func(t *testing.T) {
+		assert.NoError(t, <-index.RebuildAsync(context.Background()))
+		assert.Equal(t, []int{0}, index.Get(100))
+	})
+}
+
+func TestRebuildContextSingleWriter(t *testing.T) {
+	type Entity struct {
+		Key int
+	}
+	data := []Entity{{1}, {2}}
+	index := NewBTree(&data, func(e *Entity) int {
+		return e.Key
+	}, WithLocking(SingleWriter))
+	defer index.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	data[0].Key = 3
+	assert.ErrorIs(t, index.RebuildContext(ctx), context.Canceled)
+	assert.Nil(t, index.Get(3))
+	assert.NoError(t, index.RebuildContext(context.Background()))
+	assert.Equal(t, []int{0}, index.Get(3))
+}
+
+func TestRebuildPanic(t *testing.T) {
+	type Entity struct {
+		Key int
+	}
+	for _, mode := range []LockMode{RWLock, SingleWriter} {
+		data := []Entity{{1}, {2}, {3}}
+		broken := false
+		index := NewBTree(&data, func(e *Entity) int {
+			if broken && e.Key == 2 {
+				panic("broken extractor")
+			}
+			return e.Key
+		}, WithLocking(mode))
+		data[0].Key = 10
+		broken = true
+
+		err := index.RebuildContext(context.Background())
+		assert.True(t, errors.Is(err, errs.ErrPanic))
+		var panicErr *PanicError
+		if assert.True(t, errors.As(err, &panicErr)) {
+			assert.Equal(t, "broken extractor", panicErr.Value)
+			assert.NotEmpty(t, panicErr.Stack)
+		}
+		// the old tree is kept and the locks are released
+		assert.Equal(t, []int{0}, index.Get(1))
+		assert.Nil(t, index.Get(10))
+
+		if mode == RWLock {
+			assert.PanicsWithError(t, err.Error(), index.Rebuild)
+		} else {
+			index.Rebuild()
+			index.Sync()
+		}
+		assert.Equal(t, []int{0}, index.Get(1))
+
+		err = index.Apply([]Op[Entity]{
+			{Kind: OpRm, Item: &Entity{1}, Pos: 0},
+			{Kind: OpPut, Item: &data[1], Pos: 1},
+		})
+		assert.True(t, errors.Is(err, errs.ErrPanic))
+		assert.Equal(t, []int{0}, index.Get(1))
+
+		broken = false
+		index.Put(&data[0], 0)
+		index.Sync()
+		assert.Equal(t, []int{0}, index.Get(10))
+		if mode == SingleWriter {
+			index.Close()
+		}
+	}
+}
+
+func TestRebuildJournal(t *testing.T) {
+	type Entity struct {
+		Key   int
+		Pause bool
+	}
+	data := make([]Entity, 3000)
+	for j := range data {
+		data[j].Key = j
+	}
+	var (
+		paused = make(chan struct{})
+		resume = make(chan struct{})
+		once   sync.Once
+	)
+	index := NewBTree(&data, func(e *Entity) int {
+		if e.Pause {
+			once.Do(func() {
+				paused <- struct{}{}
+				<-resume
+			})
+		}
+		return e.Key
+	})
+	data[2000].Pause = true
+	rebuilt := index.RebuildAsync(context.Background())
+	<-paused
+
+	// swap-delete of an item the build has already read, the moved one is not read yet
+	extra := Entity{Key: 5000}
+	index.Put(&extra, 3000)
+	index.Rm(&data[10], 10)
+	assert.True(t, index.Reposition(&data[2999], 2999, 10))
+	data[10] = data[2999]
+	close(resume)
+	assert.NoError(t, <-rebuilt)
+
+	assert.Nil(t, index.Get(10))
+	assert.Equal(t, []int{10}, index.Get(2999))
+	assert.Equal(t, []int{3000}, index.Get(5000))
+	assert.Equal(t, 3000, index.Stats().Postings)
+}
+
+func TestRebuildTruncation(t *testing.T) {
+	type Entity struct {
+		Key   int
+		Pause bool
+	}
+	data := make([]Entity, 3000)
+	for j := range data {
+		data[j].Key = j
+	}
+	var (
+		paused = make(chan struct{})
+		resume = make(chan struct{})
+		once   sync.Once
+	)
+	index := NewBTree(&data, func(e *Entity) int {
+		if e.Pause {
+			once.Do(func() {
+				paused <- struct{}{}
+				<-resume
+			})
+		}
+		return e.Key
+	})
+	data[1500].Pause = true
+	rebuilt := index.RebuildAsync(context.Background())
+	<-paused
+
+	// the items the build hasn't read yet are removed and the data array is shrunk
+	for j := 0; j < 1000; j++ {
+		last := len(data) - 1
+		index.Rm(&data[last], last)
+		data = data[:last]
+	}
+	close(resume)
+	assert.NoError(t, <-rebuilt)
+
+	assert.Equal(t, []int{1999}, index.Get(1999))
+	assert.Nil(t, index.Get(2000))
+	assert.Equal(t, 2000, index.Stats().Postings)
+}
+
+func TestRebuildPostingOrder(t *testing.T) {
+	type Entity struct {
+		Key   int
+		Time  int
+		Pause bool
+	}
+	data := make([]Entity, 3000)
+	for j := range data {
+		data[j].Key, data[j].Time = j%10, j
+	}
+	var (
+		paused = make(chan struct{})
+		resume = make(chan struct{})
+		once   sync.Once
+	)
+	index := NewBTree(&data, func(e *Entity) int {
+		if e.Pause {
+			once.Do(func() {
+				paused <- struct{}{}
+				<-resume
+			})
+		}
+		return e.Key
+	}, WithPostingOrder(func(x, y *Entity) bool {
+		return x.Time < y.Time
+	}))
+	data[2000].Pause = true
+	rebuilt := index.RebuildAsync(context.Background())
+	<-paused
+
+	// the item is put and removed at a position past the end of the data array
+	waits := hookWaits(t)
+	mutated := make(chan struct{})
+	go func() {
+		defer close(mutated)
+		extra := Entity{Key: 1, Time: 5}
+		index.Put(&extra, 3000)
+		index.Rm(&extra, 3000)
+	}()
+	<-waits
+	close(resume)
+	assert.NoError(t, <-rebuilt)
+	<-mutated
+
+	postings := index.Get(1)
+	assert.Len(t, postings, 300)
+	assert.Equal(t, []int{1, 11, 21}, postings[:3])
+	assert.Equal(t, 3000, index.Stats().Postings)
+}
+
+func TestCompactJournal(t *testing.T) {
+	journal := []journalEntry[int]{
+		{kind: OpPut, key: 1, pos: 3},
+		{kind: OpRm, key: 1, pos: 3},
+		{kind: OpRm, key: 2, pos: 4},
+		{kind: OpPut, key: 2, pos: 4},
+		{kind: OpPut, key: 5, pos: 1},
+		{kind: OpReposition, key: 5, pos: 1, newPos: 2},
+		{kind: OpRm, key: 5, pos: 2},
+	}
+	assert.Equal(t, []journalEntry[int]{
+		{kind: OpRm, key: 1, pos: 3},
+		{kind: OpRm, key: 2, pos: 4},
+		{kind: OpPut, key: 2, pos: 4},
+		{kind: OpRm, key: 5, pos: 1},
+		{kind: OpRm, key: 5, pos: 2},
+	}, compact(journal))
+	assert.Empty(t, compact[int](nil))
+}
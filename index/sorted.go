@@ -0,0 +1,170 @@
+package index
+
+import (
+	"fmt"
+	"sort"
+	"unsafe"
+
+	"github.com/google/btree"
+
+	"github.com/nikk-gr/strmem/errs"
+)
+
+var _ Ordered[int] = (*SortedIndex[int, struct{}])(nil)
+
+// SortedIndex is an index for the read-only cache data array.
+// It keeps the keys and the data array indexes in two slices sorted by key
+// and finds them by binary search. It uses less memory than BTree but can't be changed
+// without Rebuild
+type SortedIndex[T btree.Ordered, A any] struct {
+	locker
+	dataPtr   *[]A
+	getField  func(cache *A) T
+	keys      []T
+	positions []int
+	sorted    bool
+	predicate func(item *A) bool
+	lazy      lazyBuild
+}
+
+// NewSortedIndex make a sorted index for the read-only cache data array
+// data is an array of any type data
+// field is a function that returns the field that should be indexed
+// opts are the index options, WithLocking, WithSortedResults, WithPredicate and WithLazyBuild are supported.
+// SingleWriter works as RWLock
+func NewSortedIndex[T btree.Ordered, A any](
+	data *[]A,
+	field func(cache *A) T,
+	opts ...Option,
+) *SortedIndex[T, A] {
+	o := newOptions(opts)
+	ind := SortedIndex[T, A]{
+		dataPtr:   data,
+		getField:  field,
+		sorted:    o.sorted,
+		predicate: predicateFor[A](o),
+	}
+	ind.locker.disabled = o.lockMode == NoLock
+	if !o.lazy {
+		ind.Rebuild()
+	}
+	return &ind
+}
+
+// byKey sorts keys and positions together
+type byKey[T btree.Ordered] struct {
+	keys      []T
+	positions []int
+}
+
+func (b byKey[T]) Len() int           { return len(b.keys) }
+func (b byKey[T]) Less(x, y int) bool { return b.keys[x] < b.keys[y] }
+func (b byKey[T]) Swap(x, y int) {
+	b.keys[x], b.keys[y] = b.keys[y], b.keys[x]
+	b.positions[x], b.positions[y] = b.positions[y], b.positions[x]
+}
+
+// Rebuild removes the old index and builds new
+func (i *SortedIndex[T, A]) Rebuild() {
+	keys := make([]T, 0, len(*i.dataPtr))
+	positions := make([]int, 0, len(*i.dataPtr))
+	for j := range *i.dataPtr {
+		if i.predicate != nil && !i.predicate(&(*i.dataPtr)[j]) {
+			continue
+		}
+		keys = append(keys, i.getField(&(*i.dataPtr)[j]))
+		positions = append(positions, j)
+	}
+	// stable sort keeps the data array indexes of every key ascending
+	sort.Stable(byKey[T]{keys, positions})
+
+	defer i.lock(opRebuild)()
+	i.keys, i.positions = keys, positions
+	i.lazy.built.Store(true)
+}
+
+// lowerBound returns the first position of the keys that is not less than key
+func (i *SortedIndex[T, A]) lowerBound(key T) int {
+	return sort.Search(len(i.keys), func(j int) bool {
+		return i.keys[j] >= key
+	})
+}
+
+// upperBound returns the first position of the keys that is greater than key
+func (i *SortedIndex[T, A]) upperBound(key T) int {
+	return sort.Search(len(i.keys), func(j int) bool {
+		return i.keys[j] > key
+	})
+}
+
+// Get returns the slice of data array indexes that match selected key
+func (i *SortedIndex[T, A]) Get(key T) []int {
+	i.lazy.ensure(i.Rebuild)
+	defer i.rlock(opGet)()
+	return i.result(i.lowerBound(key), i.upperBound(key))
+}
+
+// Find returns the slice of data array indexes whose keys match key by method.
+// It panics with an error wrapping errs.ErrInvalidSearchMethod if the method is unknown
+func (i *SortedIndex[T, A]) Find(key T, method SearchMethod) []int {
+	i.lazy.ensure(i.Rebuild)
+	defer i.rlock(opFind)()
+	switch method {
+	case EQ:
+		return i.result(i.lowerBound(key), i.upperBound(key))
+	case GT:
+		return i.result(i.upperBound(key), len(i.keys))
+	case GTE:
+		return i.result(i.lowerBound(key), len(i.keys))
+	case LT:
+		return i.result(0, i.lowerBound(key))
+	case LTE:
+		return i.result(0, i.upperBound(key))
+	default:
+		panic(fmt.Errorf("%w: %d", errs.ErrInvalidSearchMethod, method))
+	}
+}
+
+// GetRange returns the slice of data array indexes whose keys are between from and to
+func (i *SortedIndex[T, A]) GetRange(from, to T, includeFrom, includeTo bool) []int {
+	i.lazy.ensure(i.Rebuild)
+	defer i.rlock(opGetRange)()
+	if from > to {
+		to, from = from, to
+	}
+	start, end := i.upperBound(from), i.lowerBound(to)
+	if includeFrom {
+		start = i.lowerBound(from)
+	}
+	if includeTo {
+		end = i.upperBound(to)
+	}
+	return i.result(start, end)
+}
+
+// result returns the data array indexes between start and end positions of the keys
+func (i *SortedIndex[T, A]) result(start, end int) []int {
+	if start >= end {
+		return nil
+	}
+	// the positions are copied, so the caller can't break the order of the index
+	data := append([]int(nil), i.positions[start:end]...)
+	if i.sorted {
+		sort.Ints(data)
+	}
+	return data
+}
+
+// Stats returns the number of keys and postings in the index and its memory estimate
+func (i *SortedIndex[T, A]) Stats() (s Stats) {
+	defer i.rlock(opStats)()
+	for j := range i.keys {
+		if j == 0 || i.keys[j] != i.keys[j-1] {
+			s.Keys++
+		}
+	}
+	s.Postings = len(i.positions)
+	var key T
+	s.Bytes = uintptr(cap(i.keys))*unsafe.Sizeof(key) + uintptr(cap(i.positions))*postingSize
+	return s
+}
@@ -0,0 +1,144 @@
+package index
+
+import (
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSortedIndex(t *testing.T) {
+	type Entity struct {
+		I   int
+		Key uint32
+	}
+	data := []Entity{
+		{0, 6},
+		{1, 1},
+		{2, 1},
+		{3, 5},
+		{4, 6},
+		{5, 7},
+		{6, 8},
+		{7, 8},
+		{8, 10},
+		{9, 10},
+	}
+	field := func(e *Entity) uint32 {
+		return e.Key
+	}
+	indexes := map[string]Ordered[uint32]{
+		"sorted": NewSortedIndex(&data, field),
+		"btree":  NewBTree(&data, field),
+	}
+
+	tests := []struct {
+		name        string
+		query       func(index Ordered[uint32]) []int
+		expectation []int
+	}{
+		{
+			name:        "get",
+			query:       func(index Ordered[uint32]) []int { return index.Get(1) },
+			expectation: []int{1, 2},
+		},
+		{
+			name:        "get missing",
+			query:       func(index Ordered[uint32]) []int { return index.Get(2) },
+			expectation: nil,
+		},
+		{
+			name:        "gather",
+			query:       func(index Ordered[uint32]) []int { return index.Find(6, GT) },
+			expectation: []int{5, 6, 7, 8, 9},
+		},
+		{
+			name:        "gather or equal",
+			query:       func(index Ordered[uint32]) []int { return index.Find(6, GTE) },
+			expectation: []int{0, 4, 5, 6, 7, 8, 9},
+		},
+		{
+			name:        "lighter",
+			query:       func(index Ordered[uint32]) []int { return index.Find(6, LT) },
+			expectation: []int{1, 2, 3},
+		},
+		{
+			name:        "lighter or equal",
+			query:       func(index Ordered[uint32]) []int { return index.Find(6, LTE) },
+			expectation: []int{0, 1, 2, 3, 4},
+		},
+		{
+			name:        "range inclusive",
+			query:       func(index Ordered[uint32]) []int { return index.GetRange(5, 8, true, true) },
+			expectation: []int{0, 3, 4, 5, 6, 7},
+		},
+		{
+			name:        "range exclusive",
+			query:       func(index Ordered[uint32]) []int { return index.GetRange(5, 8, false, false) },
+			expectation: []int{0, 4, 5},
+		},
+		{
+			name:        "range reversed",
+			query:       func(index Ordered[uint32]) []int { return index.GetRange(8, 5, false, true) },
+			expectation: []int{0, 4, 5, 6, 7},
+		},
+		{
+			name:        "range between keys",
+			query:       func(index Ordered[uint32]) []int { return index.GetRange(2, 4, true, true) },
+			expectation: nil,
+		},
+		{
+			name:        "range of one key",
+			query:       func(index Ordered[uint32]) []int { return index.GetRange(10, 10, true, true) },
+			expectation: []int{8, 9},
+		},
+	}
+	for name, index := range indexes {
+		for _, tt := range tests {
+			t.Run(name+" "+tt.name, func(t *testing.T) {
+				actual := tt.query(index)
+				sort.Ints(actual)
+				assert.Equal(t, tt.expectation, actual)
+			})
+		}
+	}
+
+	t.Run("sorted results and stats", func(t *testing.T) {
+		index := NewSortedIndex(&data, field, WithSortedResults())
+		assert.Equal(t, []int{0, 4, 5, 6, 7, 8, 9}, index.Find(6, GTE))
+		stats := index.Stats()
+		assert.Equal(t, 6, stats.Keys)
+		assert.Equal(t, 10, stats.Postings)
+	})
+
+	t.Run("rebuild", func(t *testing.T) {
+		data := []Entity{{0, 3}, {1, 2}}
+		index := NewSortedIndex(&data, field)
+		data = append(data, Entity{2, 3})
+		assert.Equal(t, []int{0}, index.Get(3))
+		index.Rebuild()
+		assert.Equal(t, []int{0, 2}, index.Get(3))
+	})
+
+	t.Run("single writer works as rwlock", func(t *testing.T) {
+		data := []Entity{{0, 3}, {1, 2}}
+		index := NewSortedIndex(&data, field, WithLocking(SingleWriter))
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				index.Rebuild()
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				assert.Equal(t, []int{0}, index.Get(3))
+			}
+		}()
+		wg.Wait()
+		assert.NotZero(t, index.LockStats().Rebuild.Count)
+	})
+}
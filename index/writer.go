@@ -0,0 +1,131 @@
+package index
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/google/btree"
+
+	"github.com/nikk-gr/strmem/errs"
+)
+
+// writer applies the index mutations on a dedicated goroutine
+// and publishes read-only clones of the tree for the readers
+type writer[T btree.Ordered] struct {
+	queue     chan func()
+	published atomic.Pointer[btree.BTreeG[indexNode[T]]]
+	// waiters are closed after the next publication, they are used by the writer goroutine only
+	waiters []chan struct{}
+	done    chan struct{}
+	mu      sync.RWMutex
+	closed  bool
+}
+
+// writerQueueSize is the number of mutations that can be queued before the callers block
+const writerQueueSize = 1024
+
+func newWriter[T btree.Ordered](tree *btree.BTreeG[indexNode[T]]) *writer[T] {
+	w := writer[T]{
+		queue: make(chan func(), writerQueueSize),
+		done:  make(chan struct{}),
+	}
+	w.published.Store(tree)
+	return &w
+}
+
+// enqueue queues fn to be run on the writer goroutine
+func (w *writer[T]) enqueue(fn func()) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.closed {
+		panic(fmt.Errorf("index: %w", errs.ErrClosed))
+	}
+	w.queue <- fn
+}
+
+// await queues fn and waits until its result is published.
+// If fn panics, await panics with *PanicError on the calling goroutine
+func (w *writer[T]) await(fn func()) {
+	published := make(chan struct{})
+	var err error
+	w.enqueue(func() {
+		defer func() {
+			w.waiters = append(w.waiters, published)
+		}()
+		defer recoverTo(&err)
+		fn()
+	})
+	<-published
+	if err != nil {
+		panic(err)
+	}
+}
+
+// close stops the writer goroutine after all queued mutations are published
+func (w *writer[T]) close() {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.queue)
+	}
+	w.mu.Unlock()
+	<-w.done
+}
+
+// write is the writer goroutine of the SingleWriter mode.
+// It applies all queued mutations and publishes a clone of the tree once the queue is empty
+func (i *BTree[T, A]) write() {
+	w := i.writer
+	defer close(w.done)
+	for fn := range w.queue {
+		w.run(fn)
+		w.drain()
+		w.published.Store(i.tree.Clone())
+		for _, ch := range w.waiters {
+			close(ch)
+		}
+		w.waiters = w.waiters[:0]
+	}
+}
+
+// drain runs the queued mutations until the queue is empty
+func (w *writer[T]) drain() {
+	for {
+		select {
+		case fn, ok := <-w.queue:
+			if !ok {
+				return
+			}
+			w.run(fn)
+		default:
+			return
+		}
+	}
+}
+
+// run runs the queued mutation. A panicking mutation is dropped,
+// so it doesn't stop the writer goroutine with the mutations queued after it
+func (w *writer[T]) run(fn func()) {
+	defer func() {
+		_ = recover()
+	}()
+	fn()
+}
+
+// Sync waits until all mutations queued before the call are visible to the readers.
+// It returns immediately if the index is not in the SingleWriter mode
+func (i *BTree[T, A]) Sync() {
+	if i.writer != nil {
+		i.writer.await(noop)
+	}
+}
+
+// Close stops the writer goroutine of the SingleWriter mode after all queued mutations are published.
+// Mutations of the closed index panic with an error wrapping errs.ErrClosed, reads keep working.
+// Close does nothing if the index is not in the SingleWriter mode
+func (i *BTree[T, A]) Close() {
+	if i.writer != nil {
+		i.writer.close()
+	}
+}
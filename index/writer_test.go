@@ -0,0 +1,134 @@
+package index
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/nikk-gr/strmem/errs"
+)
+
+func TestSingleWriter(t *testing.T) {
+	type Entity struct {
+		Key int
+	}
+	field := func(e *Entity) int {
+		return e.Key
+	}
+
+	t.Run("mutations are visible after sync", func(t *testing.T) {
+		data := []Entity{{1}, {2}, {2}}
+		index := NewBTree(&data, field, WithLocking(SingleWriter))
+		defer index.Close()
+		assert.Equal(t, []int{0}, index.Get(1))
+
+		data = append(data, Entity{1})
+		index.Put(&data[3], 3)
+		index.Rm(&data[1], 1)
+		index.Sync()
+		actual := index.Get(1)
+		sort.Ints(actual)
+		assert.Equal(t, []int{0, 3}, actual)
+		assert.Equal(t, []int{2}, index.Get(2))
+	})
+
+	t.Run("apply waits for the batch", func(t *testing.T) {
+		data := []Entity{{1}, {2}}
+		index := NewBTree(&data, field, WithLocking(SingleWriter))
+		defer index.Close()
+		err := index.Apply([]Op[Entity]{
+			{Kind: OpReposition, Item: &data[1], Pos: 1, NewPos: 5},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, []int{5}, index.Get(2))
+
+		err = index.Apply([]Op[Entity]{
+			{Kind: OpRm, Item: &data[0], Pos: 0},
+			{Kind: OpRm, Item: &data[1], Pos: 1},
+		})
+		assert.True(t, errors.Is(err, errs.ErrNotFound))
+		assert.Equal(t, []int{0}, index.Get(1))
+	})
+
+	t.Run("concurrent reads and writes", func(t *testing.T) {
+		data := make([]Entity, 1000)
+		for j := range data {
+			data[j].Key = j % 10
+		}
+		index := NewBTree(&data, field, WithLocking(SingleWriter))
+		var wg sync.WaitGroup
+		for r := 0; r < 4; r++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 1000; j++ {
+					index.Find(5, LT)
+				}
+			}()
+		}
+		for j := range data {
+			index.Reposition(&data[j], j, j+len(data))
+		}
+		wg.Wait()
+		index.Close()
+		assert.Len(t, index.Find(5, LT), 500)
+		for _, pos := range index.Get(3) {
+			assert.GreaterOrEqual(t, pos, len(data))
+		}
+	})
+
+	t.Run("mutation after close", func(t *testing.T) {
+		data := []Entity{{1}}
+		index := NewBTree(&data, field, WithLocking(SingleWriter))
+		index.Close()
+		index.Close()
+		assert.Equal(t, []int{0}, index.Get(1))
+		defer func() {
+			err, _ := recover().(error)
+			assert.True(t, errors.Is(err, errs.ErrClosed))
+		}()
+		index.Put(&data[0], 1)
+	})
+
+	t.Run("panicking mutation", func(t *testing.T) {
+		data := []Entity{{1}}
+		index := NewBTree(&data, field, WithLocking(SingleWriter))
+		defer index.Close()
+		// a queued mutation is dropped, the writer goroutine keeps running
+		index.writer.enqueue(func() {
+			panic("broken mutation")
+		})
+		index.Sync()
+		// an awaited one panics on the calling goroutine
+		func() {
+			defer func() {
+				err, _ := recover().(error)
+				assert.True(t, errors.Is(err, errs.ErrPanic))
+			}()
+			index.writer.await(func() {
+				panic("broken mutation")
+			})
+		}()
+		data = append(data, Entity{1})
+		index.Put(&data[1], 1)
+		index.Sync()
+		assert.Equal(t, []int{0, 1}, index.Get(1))
+	})
+
+	t.Run("panicking extractor in constructor", func(t *testing.T) {
+		data := []Entity{{1}}
+		defer func() {
+			var panicErr *PanicError
+			err, _ := recover().(error)
+			if assert.True(t, errors.As(err, &panicErr)) {
+				assert.Equal(t, "broken extractor", panicErr.Value)
+			}
+		}()
+		NewBTree(&data, func(e *Entity) int {
+			panic("broken extractor")
+		}, WithLocking(SingleWriter))
+	})
+}
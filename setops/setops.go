@@ -0,0 +1,109 @@
+// Package setops contains the operations on sets of data array indexes returned by the indexes.
+// Sets are slices sorted in ascending order, see index.WithSortedResults
+package setops
+
+import (
+	"context"
+	"sort"
+)
+
+// gallopRatio is the size ratio of the sets from which Intersect uses galloping search
+const gallopRatio = 32
+
+// Intersect returns the indexes present in both sorted sets.
+// It merges the sets linearly when they are of similar size and uses galloping search
+// over the larger set when one of them is much smaller
+func Intersect(a, b []int) []int {
+	if len(a) > len(b) {
+		a, b = b, a
+	}
+	if len(a) == 0 {
+		return nil
+	}
+	if len(b)/len(a) >= gallopRatio {
+		return IntersectGallop(a, b)
+	}
+	return IntersectMerge(a, b)
+}
+
+// IntersectAll returns the indexes present in all sorted sets.
+// The sets are intersected from the smallest one
+func IntersectAll(sets ...[]int) []int {
+	res, _ := IntersectAllContext(context.Background(), sets...)
+	return res
+}
+
+// IntersectAllContext is IntersectAll that checks ctx before every intersection
+// and returns ctx.Err() when it is done
+func IntersectAllContext(ctx context.Context, sets ...[]int) ([]int, error) {
+	if len(sets) == 0 {
+		return nil, nil
+	}
+	sorted := append([][]int(nil), sets...)
+	sort.Slice(sorted, func(x, y int) bool {
+		return len(sorted[x]) < len(sorted[y])
+	})
+	res := sorted[0]
+	for _, set := range sorted[1:] {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if len(res) == 0 {
+			return nil, nil
+		}
+		res = Intersect(res, set)
+	}
+	return append([]int(nil), res...), nil
+}
+
+// IntersectMerge returns the indexes present in both sorted sets by linear merge
+func IntersectMerge(a, b []int) []int {
+	var res []int
+	for x, y := 0, 0; x < len(a) && y < len(b); {
+		switch {
+		case a[x] < b[y]:
+			x++
+		case a[x] > b[y]:
+			y++
+		default:
+			res = append(res, a[x])
+			x++
+			y++
+		}
+	}
+	return res
+}
+
+// IntersectGallop returns the indexes present in both sorted sets.
+// Every index of small is looked up in large by exponential search from the previous match,
+// so the cost is O(len(small) * log(len(large)/len(small)))
+func IntersectGallop(small, large []int) []int {
+	var res []int
+	start := 0
+	for _, val := range small {
+		start = gallop(large, start, val)
+		if start == len(large) {
+			break
+		}
+		if large[start] == val {
+			res = append(res, val)
+			start++
+		}
+	}
+	return res
+}
+
+// gallop returns the first position of arr from start whose value is not less than val
+func gallop(arr []int, start, val int) int {
+	step := 1
+	end := start
+	for end < len(arr) && arr[end] < val {
+		start = end + 1
+		end += step
+		step *= 2
+	}
+	if end > len(arr) {
+		end = len(arr)
+	}
+	return start + sort.SearchInts(arr[start:end], val)
+}
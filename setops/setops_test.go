@@ -0,0 +1,103 @@
+package setops
+
+import (
+	"context"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIntersect(t *testing.T) {
+	tests := []struct {
+		name        string
+		a           []int
+		b           []int
+		expectation []int
+	}{
+		{
+			name:        "empty",
+			a:           nil,
+			b:           []int{1, 2},
+			expectation: nil,
+		},
+		{
+			name:        "no common",
+			a:           []int{1, 3, 5},
+			b:           []int{2, 4, 6},
+			expectation: nil,
+		},
+		{
+			name:        "some common",
+			a:           []int{1, 2, 3, 5, 8},
+			b:           []int{2, 3, 4, 8, 9},
+			expectation: []int{2, 3, 8},
+		},
+		{
+			name:        "small and large",
+			a:           []int{0, 63, 64, 1000, 5000},
+			b:           sequence(0, 2000, 1),
+			expectation: []int{0, 63, 64, 1000},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expectation, Intersect(tt.a, tt.b))
+			assert.Equal(t, tt.expectation, Intersect(tt.b, tt.a))
+			assert.Equal(t, tt.expectation, IntersectMerge(tt.a, tt.b))
+			assert.Equal(t, tt.expectation, IntersectGallop(tt.a, tt.b))
+		})
+	}
+}
+
+func TestIntersectRandom(t *testing.T) {
+	for n := 0; n < 100; n++ {
+		small := randomSet(rand.Intn(20), 10000)
+		large := randomSet(rand.Intn(5000), 10000)
+		assert.Equal(t, IntersectMerge(small, large), IntersectGallop(small, large))
+	}
+}
+
+func TestIntersectAll(t *testing.T) {
+	assert.Nil(t, IntersectAll())
+	assert.Equal(t, []int{6, 12}, IntersectAll(
+		sequence(0, 20, 2),
+		sequence(0, 20, 3),
+		[]int{1, 6, 12, 13},
+	))
+	assert.Nil(t, IntersectAll([]int{1}, []int{2}, []int{1, 2}))
+}
+
+func TestIntersectAllContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	res, err := IntersectAllContext(ctx, []int{1, 2}, []int{2, 3})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{2}, res)
+
+	cancel()
+	res, err = IntersectAllContext(ctx, []int{1, 2}, []int{2, 3})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Nil(t, res)
+}
+
+func sequence(from, to, step int) []int {
+	var res []int
+	for j := from; j < to; j += step {
+		res = append(res, j)
+	}
+	return res
+}
+
+func randomSet(n, limit int) []int {
+	set := map[int]bool{}
+	for len(set) < n {
+		set[rand.Intn(limit)] = true
+	}
+	res := make([]int, 0, n)
+	for val := range set {
+		res = append(res, val)
+	}
+	sort.Ints(res)
+	return res
+}